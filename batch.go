@@ -2,9 +2,11 @@ package bitcaskgo
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/index"
 	"encoding/binary"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const nonTransactionSeqNo uint64 = 0
@@ -21,9 +23,6 @@ type WriteBatch struct {
 
 // 初始化
 func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
-	if db.options.IndexType == BPTree && !db.seqNoFileExists && !db.isInitial {
-		panic("cannot use write batch without seq file for bptree")
-	}
 	return &WriteBatch{
 		options:       opts,
 		mu:            new(sync.Mutex),
@@ -58,7 +57,10 @@ func (wb *WriteBatch) Delete(key []byte) error {
 	defer wb.mu.Unlock()
 
 	// 数据不存在则之间返回
-	logRecordPos := wb.db.index.Get(key)
+	logRecordPos, err := wb.db.getIndex(key)
+	if err != nil {
+		return err
+	}
 	if logRecordPos == nil {
 		if wb.pendingWrites[string(key)] != nil {
 			delete(wb.pendingWrites, string(key))
@@ -76,7 +78,7 @@ func (wb *WriteBatch) Delete(key []byte) error {
 }
 
 // 提交事务，将暂存的数据写到数据文件，并更新内存索引
-func (wb *WriteBatch) Commit() error {
+func (wb *WriteBatch) Commit() (err error) {
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
@@ -87,23 +89,62 @@ func (wb *WriteBatch) Commit() error {
 		return ErrExceedMaxBatchNum
 	}
 
+	// 先按固定顺序锁住批次内所有 key 所在的分段，再获取全局锁写入数据文件
+	keys := make([][]byte, 0, len(wb.pendingWrites))
+	for _, record := range wb.pendingWrites {
+		keys = append(keys, record.Key)
+	}
+	unlockKeys := wb.db.keyLocks.lockKeys(keys)
+	defer unlockKeys()
+	start := time.Now()
+	defer func() { wb.db.metrics.observe(&wb.db.metrics.batchCommits, wb.db.metrics.writeLatency, start, err) }()
+
+	if err := wb.appendPendingWrites(); err != nil {
+		return err
+	}
+
+	// 清空暂存的数据
+	wb.pendingWrites = make(map[string]*data.LogRecord)
+	return nil
+}
+
+// appendPendingWrites 在全局锁内将暂存的数据写入数据文件，索引的更新不需要持有全局锁
+func (wb *WriteBatch) appendPendingWrites() error {
 	wb.db.mu.Lock()
-	defer wb.db.mu.Unlock()
+	wb.db.beginIndexUpdate()
 	// 获取当前最新的事务的序列号
 	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
 
-	// 开始写数据到数据文件中
-	positions := make(map[string]*data.LogRecordPos)
+	// 开始写数据到数据文件中，同一批次的数据使用相同的写入时间
+	now := time.Now().UnixNano()
+	ops := make([]index.BatchOp, 0, len(wb.pendingWrites))
+	var events []Event
+	watching := wb.db.watchHub.watching()
 	for _, record := range wb.pendingWrites {
 		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(record.Key, seqNo),
-			Value: record.Value,
-			Type:  record.Type,
+			Key:       logRecordKeyWithSeq(record.Key, seqNo),
+			Value:     record.Value,
+			Type:      record.Type,
+			Timestamp: now,
 		})
 		if err != nil {
+			wb.db.discardPendingOps(ops)
+			wb.db.abortIndexUpdate()
+			wb.db.mu.Unlock()
 			return err
 		}
-		positions[string(record.Key)] = logRecordPos
+		ops = append(ops, index.BatchOp{
+			Key:    record.Key,
+			Pos:    logRecordPos,
+			Delete: record.Type == data.LogRecordDeleted,
+		})
+		if watching {
+			event := Event{Type: EventPut, Key: record.Key, Value: record.Value, Timestamp: eventTime(now)}
+			if record.Type == data.LogRecordDeleted {
+				event.Type = EventDelete
+			}
+			events = append(events, event)
+		}
 	}
 
 	// 写一条标识事务完成的数据
@@ -111,35 +152,143 @@ func (wb *WriteBatch) Commit() error {
 		Key:  logRecordKeyWithSeq(txnFinKey, seqNo),
 		Type: data.LogRecordTxnFinished,
 	}
-	if _, err := wb.db.appendLogRecord(finishRecord); err != nil {
+	finishPos, err := wb.db.appendLogRecord(finishRecord)
+	if err != nil {
+		wb.db.discardPendingOps(ops)
+		wb.db.abortIndexUpdate()
+		wb.db.mu.Unlock()
 		return err
 	}
 
 	// 根据配置项判断是否需要将数据同步到磁盘
 	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
+		if err := wb.db.syncActiveFile(); err != nil {
+			wb.db.abortIndexUpdate()
+			wb.db.mu.Unlock()
 			return err
 		}
 	}
+	ticket := wb.db.watchHub.reserve()
+	wb.db.mu.Unlock()
+	defer wb.db.endIndexUpdate()
 
-	// 更新内存索引
-	for _, record := range wb.pendingWrites {
-		pos := positions[string(record.Key)]
-		var oldPos *data.LogRecordPos
-		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
-		}
-		if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
+	// 批量更新内存索引
+	for _, op := range ops {
+		if op.Delete {
+			wb.db.metrics.deletes.Inc()
+		} else {
+			wb.db.metrics.puts.Inc()
+			wb.db.addToBloomFilter(op.Key)
 		}
+	}
+	for _, oldPos := range wb.db.index.ApplyBatch(ops) {
 		if oldPos != nil {
-			wb.db.reclaimSize += int64(oldPos.Size)
+			wb.db.markStale(oldPos)
 		}
 	}
+	// 同一批次的变更作为一组发布
+	wb.db.watchHub.publish(ticket, endSeq(finishPos), events)
+	return wb.db.indexErr()
+}
 
-	// 清空暂存的数据
-	wb.pendingWrites = make(map[string]*data.LogRecord)
-	return nil
+// discardPendingOps 没有写入完成标识的事务不会生效，已经写入的数据都是无效数据
+func (db *DB) discardPendingOps(ops []index.BatchOp) {
+	for _, op := range ops {
+		db.markStale(op.Pos)
+	}
+}
+
+// DeleteRange 删除 [start, end) 范围内的所有 key，start 为空表示从第一个 key 开始，end 为空表示直到最后一个 key
+// 范围内的所有 key 在同一个事务中删除，崩溃后要么全部删除，要么全部保留。
+// 删除期间会阻塞所有的写入，删除开始之后写入的 key 不会被删除
+func (db *DB) DeleteRange(start, end []byte) error {
+	return db.deleteAll(IteratorOptions{LowerBound: start, UpperBound: end})
+}
+
+// DeletePrefix 删除所有以 prefix 开头的 key，prefix 不能为空，和 DeleteRange 一样在一个事务中删除
+func (db *DB) DeletePrefix(prefix []byte) error {
+	if len(prefix) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.deleteAll(IteratorOptions{Prefix: prefix})
+}
+
+// deleteAll 删除迭代器遍历到的所有 key
+// 锁住所有 key 的分段之后，在全局锁内遍历一次索引，边遍历边写入删除记录，所有记录使用同一个事务序列号，
+// 最后写入事务完成标识。只暂存更新索引需要的 key 和位置信息，不暂存完整的数据
+func (db *DB) deleteAll(opts IteratorOptions) (err error) {
+	unlockKeys := db.keyLocks.lockAll()
+	defer unlockKeys()
+
+	db.mu.Lock()
+	// B+ 树索引的迭代器持有读事务，需要在更新索引之前关闭
+	iterator := db.NewIterator(opts)
+	if !iterator.Valid() {
+		iterator.Close()
+		db.mu.Unlock()
+		return nil
+	}
+	start := time.Now()
+	defer func() { db.metrics.observe(&db.metrics.batchCommits, db.metrics.writeLatency, start, err) }()
+	db.beginIndexUpdate()
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
+
+	now := time.Now().UnixNano()
+	var ops []index.BatchOp
+	var events []Event
+	watching := db.watchHub.watching()
+	for ; iterator.Valid(); iterator.Next() {
+		key := iterator.Key()
+		pos, err := db.appendLogRecord(&data.LogRecord{
+			Key:       logRecordKeyWithSeq(key, seqNo),
+			Type:      data.LogRecordDeleted,
+			Timestamp: now,
+		})
+		if err != nil {
+			iterator.Close()
+			db.discardPendingOps(ops)
+			db.abortIndexUpdate()
+			db.mu.Unlock()
+			return err
+		}
+		ops = append(ops, index.BatchOp{Key: key, Pos: pos, Delete: true})
+		if watching {
+			events = append(events, Event{Type: EventDelete, Key: key, Timestamp: eventTime(now)})
+		}
+	}
+	iterator.Close()
+
+	// 写一条标识事务完成的数据，之前的删除记录在此之后才会生效
+	finishPos, err := db.appendLogRecord(&data.LogRecord{
+		Key:  logRecordKeyWithSeq(txnFinKey, seqNo),
+		Type: data.LogRecordTxnFinished,
+	})
+	if err != nil {
+		db.discardPendingOps(ops)
+		db.abortIndexUpdate()
+		db.mu.Unlock()
+		return err
+	}
+	if DefaultWriteBatchOptions.SyncWrites && db.activeFile != nil {
+		if err := db.syncActiveFile(); err != nil {
+			db.abortIndexUpdate()
+			db.mu.Unlock()
+			return err
+		}
+	}
+	ticket := db.watchHub.reserve()
+	db.mu.Unlock()
+	defer db.endIndexUpdate()
+
+	// 所有 key 的分段都被锁住，范围内的 key 不会被并发修改
+	for _, oldPos := range db.index.ApplyBatch(ops) {
+		db.metrics.deletes.Inc()
+		if oldPos != nil {
+			db.markStale(oldPos)
+		}
+	}
+	db.watchHub.publish(ticket, endSeq(finishPos), events)
+	return db.indexErr()
 }
 
 // Key + Sqe Number 编码
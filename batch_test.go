@@ -1,7 +1,10 @@
 package bitcaskgo
 
 import (
+	"bitcask-go/data"
 	"bitcask-go/utils"
+	"fmt"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -75,3 +78,76 @@ func TestDB_WriteBatch2(t *testing.T) {
 	assert.Nil(t, val1)
 	assert.Equal(t, uint64(2), db2.seqNo)
 }
+
+func TestDB_DeleteRange(t *testing.T) {
+	opts := DefaultOptions
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	// 超过默认的单批次数量上限
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("tenant-a/%04d", i)), utils.RandomValue(10)))
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("tenant-b/%04d", i)), utils.RandomValue(10)))
+	}
+	assert.Nil(t, db.Put([]byte("tenant-c"), utils.RandomValue(10)))
+
+	assert.Nil(t, db.DeleteRange([]byte("tenant-a/0100"), []byte("tenant-a/0200")))
+	_, err = db.Get([]byte("tenant-a/0100"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get([]byte("tenant-a/0199"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get([]byte("tenant-a/0200"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint(3901), mustStat(t, db).KeyNum)
+
+	assert.Nil(t, db.DeletePrefix([]byte("tenant-b/")))
+	assert.Equal(t, uint(1901), mustStat(t, db).KeyNum)
+	assert.Equal(t, ErrKeyIsEmpty, db.DeletePrefix(nil))
+	// 范围内没有数据
+	assert.Nil(t, db.DeleteRange([]byte("x"), []byte("y")))
+
+	// 重启之后删除仍然有效
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1901), mustStat(t, db).KeyNum)
+	_, err = db.Get([]byte("tenant-b/0000"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get([]byte("tenant-c"))
+	assert.Nil(t, err)
+}
+
+func TestDB_DeleteRange_Atomic(t *testing.T) {
+	opts := DefaultOptions
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("tenant-a/%04d", i)), utils.RandomValue(10)))
+	}
+	assert.Nil(t, db.DeletePrefix([]byte("tenant-a/")))
+	assert.Equal(t, uint(0), mustStat(t, db).KeyNum)
+
+	// 所有的删除记录在同一个事务中，最后一条记录是事务完成标识
+	finishRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:  logRecordKeyWithSeq(txnFinKey, db.seqNo),
+		Type: data.LogRecordTxnFinished,
+	})
+	finishOff := db.activeFile.WriteOff - int64(len(finishRecord))
+	record, _, err := db.activeFile.ReadLogRecord(finishOff)
+	assert.Nil(t, err)
+	assert.Equal(t, data.LogRecordTxnFinished, record.Type)
+
+	// 崩溃时没有写入事务完成标识，所有的删除都不生效
+	fileName := data.GetDataFileName(opts.DirPath, db.activeFile.FileId)
+	crashDB(t, db)
+	assert.Nil(t, os.Truncate(fileName, finishOff))
+
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(3000), mustStat(t, db).KeyNum)
+	_, err = db.Get([]byte("tenant-a/2999"))
+	assert.Nil(t, err)
+}
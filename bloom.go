@@ -0,0 +1,59 @@
+package bitcaskgo
+
+import (
+	"bitcask-go/bloom"
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"fmt"
+)
+
+// 布隆过滤器初始容量的下限
+const bloomFilterMinCapacity = 1 << 16
+
+// initBloomFilter 为 B+ 树索引创建布隆过滤器，并写入索引中所有的 key
+// B+ 树索引保存在磁盘上，查询不存在的 key 也需要访问磁盘，先经过布隆过滤器可以直接返回
+func (db *DB) initBloomFilter() {
+	if db.options.IndexType != BPTree || db.options.BloomFilterFPRate <= 0 {
+		return
+	}
+	capacity := int64(db.index.Size()) * 2
+	if capacity < bloomFilterMinCapacity {
+		capacity = bloomFilterMinCapacity
+	}
+	filter := bloom.New(capacity, db.options.BloomFilterFPRate)
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		filter.Add(iterator.Key())
+	}
+	db.bloomFilter = filter
+}
+
+// addToBloomFilter 将 key 写入布隆过滤器，必须在更新索引之前调用
+func (db *DB) addToBloomFilter(key []byte) {
+	if db.bloomFilter != nil {
+		db.bloomFilter.Add(key)
+	}
+}
+
+// getIndex 从索引中取出 key 对应的位置信息，布隆过滤器判断 key 不存在时不再访问索引
+// key 不存在时返回 nil，索引发生错误时返回错误
+func (db *DB) getIndex(key []byte) (*data.LogRecordPos, error) {
+	if db.bloomFilter != nil && !db.bloomFilter.MayContain(key) {
+		return nil, nil
+	}
+	if pos := db.index.Get(key); pos != nil {
+		return pos, nil
+	}
+	return nil, db.indexErr()
+}
+
+// indexErr 返回索引发生的错误，B+ 树索引读写磁盘失败之后需要重新打开数据库
+func (db *DB) indexErr() error {
+	if reporter, ok := db.index.(index.ErrReporter); ok {
+		if err := reporter.Err(); err != nil {
+			return fmt.Errorf("%w: %w", ErrIndexFailed, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package bloom
+
+import (
+	"hash/maphash"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// Filter 可扩展的布隆过滤器
+// 由多层位数组组成，当前层写满之后追加一层容量翻倍、误判率减半的新层，
+// 所有层的误判率之和不超过创建时指定的误判率，写入的 key 数量超过预期时也不需要重建。
+// Add 和 MayContain 可以并发调用
+type Filter struct {
+	seed   maphash.Seed
+	fpRate float64
+	layers atomic.Pointer[[]*layer]
+	mu     sync.Mutex // 追加新层时的互斥锁
+}
+
+// layer 过滤器中的一层
+type layer struct {
+	bits     []atomic.Uint64
+	m        uint64 // 位数组的长度
+	k        uint64 // 哈希函数的数量
+	fpRate   float64
+	capacity int64 // 这一层最多容纳的 key 数量
+	count    atomic.Int64
+}
+
+// New 初始化布隆过滤器，capacity 为预计写入的 key 数量，fpRate 为期望的误判率
+func New(capacity int64, fpRate float64) *Filter {
+	f := &Filter{
+		seed:   maphash.MakeSeed(),
+		fpRate: fpRate,
+	}
+	layers := []*layer{newLayer(capacity, fpRate/2)}
+	f.layers.Store(&layers)
+	return f
+}
+
+func newLayer(capacity int64, fpRate float64) *layer {
+	if capacity < 1 {
+		capacity = 1
+	}
+	// m = -n*ln(p)/(ln2)^2，k = m/n*ln2
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &layer{
+		bits:     make([]atomic.Uint64, (m+63)/64),
+		m:        m,
+		k:        k,
+		fpRate:   fpRate,
+		capacity: capacity,
+	}
+}
+
+// Add 将 key 加入过滤器
+func (f *Filter) Add(key []byte) {
+	h := maphash.Bytes(f.seed, key)
+	layers := *f.layers.Load()
+	// 已经存在的 key 不再重复写入，避免反复更新同一个 key 时过早扩容
+	for _, l := range layers {
+		if l.mayContain(h) {
+			return
+		}
+	}
+	last := layers[len(layers)-1]
+	last.add(h)
+	if last.count.Add(1) >= last.capacity {
+		f.grow(last)
+	}
+}
+
+// MayContain key 是否可能存在，返回 false 时 key 一定不存在
+func (f *Filter) MayContain(key []byte) bool {
+	h := maphash.Bytes(f.seed, key)
+	for _, l := range *f.layers.Load() {
+		if l.mayContain(h) {
+			return true
+		}
+	}
+	return false
+}
+
+// MemSize 过滤器占用的内存大小，以字节为单位
+func (f *Filter) MemSize() int64 {
+	var size int64
+	for _, l := range *f.layers.Load() {
+		size += int64(len(l.bits)) * 8
+	}
+	return size
+}
+
+// grow 最后一层写满时追加新的一层
+func (f *Filter) grow(full *layer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	layers := *f.layers.Load()
+	if layers[len(layers)-1] != full {
+		return
+	}
+	newLayers := make([]*layer, len(layers), len(layers)+1)
+	copy(newLayers, layers)
+	newLayers = append(newLayers, newLayer(full.capacity*2, full.fpRate/2))
+	f.layers.Store(&newLayers)
+}
+
+// add 使用双重哈希由一个 64 位哈希值生成 k 个位置
+func (l *layer) add(h uint64) {
+	h1, h2 := h, h>>32|1
+	for i := uint64(0); i < l.k; i++ {
+		bit := (h1 + i*h2) % l.m
+		l.bits[bit/64].Or(1 << (bit % 64))
+	}
+}
+
+func (l *layer) mayContain(h uint64) bool {
+	h1, h2 := h, h>>32|1
+	for i := uint64(0); i < l.k; i++ {
+		bit := (h1 + i*h2) % l.m
+		if l.bits[bit/64].Load()&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package bloom
+
+import (
+	"bitcask-go/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter_MayContain(t *testing.T) {
+	f := New(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		f.Add(utils.GetTestKey(i))
+	}
+	// 写入过的 key 一定能查到
+	for i := 0; i < 10000; i++ {
+		assert.True(t, f.MayContain(utils.GetTestKey(i)))
+	}
+
+	var falsePositives int
+	for i := 10000; i < 20000; i++ {
+		if f.MayContain(utils.GetTestKey(i)) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, 200)
+}
+
+func TestFilter_Grow(t *testing.T) {
+	f := New(100, 0.01)
+	size := f.MemSize()
+
+	// 重复写入同一个 key 不会扩容
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte("bitcask"))
+	}
+	assert.Equal(t, size, f.MemSize())
+
+	// 写入的 key 超过容量时追加新层，误判率仍然在预期范围内
+	for i := 0; i < 10000; i++ {
+		f.Add(utils.GetTestKey(i))
+	}
+	assert.Greater(t, f.MemSize(), size)
+	for i := 0; i < 10000; i++ {
+		assert.True(t, f.MayContain(utils.GetTestKey(i)))
+	}
+	var falsePositives int
+	for i := 10000; i < 20000; i++ {
+		if f.MayContain(utils.GetTestKey(i)) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, 200)
+}
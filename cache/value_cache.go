@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"bitcask-go/data"
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// 分片数量，减少并发读取时的锁竞争
+const shardCount = 16
+
+// 每个缓存项除 value 之外额外占用的内存估算值（链表节点、map 项等）
+const entryOverhead = 64
+
+// ValueCache 按 LogRecordPos 缓存已解码 value 的分片 LRU 缓存
+// 数据文件是追加写入的，同一个位置上的数据不会改变，因此只有在数据文件被移除时才需要失效
+type ValueCache struct {
+	shards []*shard
+	hits   uint64 // 命中次数
+	misses uint64 // 未命中次数
+}
+
+type cacheKey struct {
+	fid    uint32
+	offset int64
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	value []byte
+}
+
+// shard 单个分片，独立加锁和淘汰
+type shard struct {
+	mu       *sync.Mutex
+	capacity int64
+	size     int64
+	lru      *list.List // 最近使用的数据位于队头
+	entries  map[cacheKey]*list.Element
+}
+
+// NewValueCache 初始化缓存，capacity 为所有分片占用内存的总上限，单位为字节
+func NewValueCache(capacity int64) *ValueCache {
+	shards := make([]*shard, shardCount)
+	for i := range shards {
+		shards[i] = &shard{
+			mu:       new(sync.Mutex),
+			capacity: capacity / shardCount,
+			lru:      list.New(),
+			entries:  make(map[cacheKey]*list.Element),
+		}
+	}
+	return &ValueCache{shards: shards}
+}
+
+// Get 根据位置信息获取缓存的 value，返回的是 value 的拷贝
+func (vc *ValueCache) Get(pos *data.LogRecordPos) ([]byte, bool) {
+	key := cacheKey{fid: pos.Fid, offset: pos.Offset}
+	value, ok := vc.getShard(key).get(key)
+	if !ok {
+		atomic.AddUint64(&vc.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&vc.hits, 1)
+	return value, true
+}
+
+// Put 缓存位置信息对应的 value，超出容量时淘汰最久未使用的数据
+func (vc *ValueCache) Put(pos *data.LogRecordPos, value []byte) {
+	key := cacheKey{fid: pos.Fid, offset: pos.Offset}
+	vc.getShard(key).put(key, value)
+}
+
+// RemoveFile 移除指定数据文件的所有缓存数据，在数据文件被删除或替换时调用
+func (vc *ValueCache) RemoveFile(fid uint32) {
+	for _, s := range vc.shards {
+		s.removeFile(fid)
+	}
+}
+
+// Stats 返回缓存的命中次数、未命中次数以及当前占用的内存大小
+func (vc *ValueCache) Stats() (hits uint64, misses uint64, size int64) {
+	for _, s := range vc.shards {
+		s.mu.Lock()
+		size += s.size
+		s.mu.Unlock()
+	}
+	return atomic.LoadUint64(&vc.hits), atomic.LoadUint64(&vc.misses), size
+}
+
+func (vc *ValueCache) getShard(key cacheKey) *shard {
+	h := (uint64(key.fid)<<40 ^ uint64(key.offset)) * 0x9E3779B97F4A7C15
+	return vc.shards[h>>60]
+}
+
+func (s *shard) get(key cacheKey) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	s.lru.MoveToFront(elem)
+	value := elem.Value.(*cacheEntry).value
+	return append(make([]byte, 0, len(value)), value...), true
+}
+
+func (s *shard) put(key cacheKey, value []byte) {
+	entrySize := int64(len(value)) + entryOverhead
+	// 单个 value 超过分片容量时不缓存
+	if entrySize > s.capacity {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[key]; ok {
+		return
+	}
+	entry := &cacheEntry{key: key, value: append(make([]byte, 0, len(value)), value...)}
+	s.entries[key] = s.lru.PushFront(entry)
+	s.size += entrySize
+
+	for s.size > s.capacity {
+		s.remove(s.lru.Back())
+	}
+}
+
+func (s *shard) removeFile(fid uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, elem := range s.entries {
+		if key.fid == fid {
+			s.remove(elem)
+		}
+	}
+}
+
+// remove 在访问此方法前必须持有互斥锁
+func (s *shard) remove(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	s.lru.Remove(elem)
+	delete(s.entries, entry.key)
+	s.size -= int64(len(entry.value)) + entryOverhead
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"bitcask-go/data"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValueCache_Get(t *testing.T) {
+	vc := NewValueCache(1024 * 1024)
+
+	// 不存在的数据
+	val1, ok1 := vc.Get(&data.LogRecordPos{Fid: 1, Offset: 0})
+	assert.False(t, ok1)
+	assert.Nil(t, val1)
+
+	vc.Put(&data.LogRecordPos{Fid: 1, Offset: 0}, []byte("bitcask kv"))
+	val2, ok2 := vc.Get(&data.LogRecordPos{Fid: 1, Offset: 0})
+	assert.True(t, ok2)
+	assert.Equal(t, []byte("bitcask kv"), val2)
+
+	// 修改返回的数据不影响缓存
+	val2[0] = 'a'
+	val3, ok3 := vc.Get(&data.LogRecordPos{Fid: 1, Offset: 0})
+	assert.True(t, ok3)
+	assert.Equal(t, []byte("bitcask kv"), val3)
+
+	hits, misses, size := vc.Stats()
+	assert.Equal(t, uint64(2), hits)
+	assert.Equal(t, uint64(1), misses)
+	assert.Equal(t, int64(10+entryOverhead), size)
+}
+
+func TestValueCache_Evict(t *testing.T) {
+	// 每个分片只能容纳一条数据
+	vc := NewValueCache(shardCount * (100 + entryOverhead))
+
+	for i := 0; i < 1000; i++ {
+		vc.Put(&data.LogRecordPos{Fid: 1, Offset: int64(i * 100)}, make([]byte, 100))
+	}
+	_, _, size := vc.Stats()
+	assert.True(t, size <= shardCount*(100+entryOverhead))
+
+	// 超过分片容量的数据不缓存
+	vc.Put(&data.LogRecordPos{Fid: 2, Offset: 0}, make([]byte, 1024))
+	_, ok := vc.Get(&data.LogRecordPos{Fid: 2, Offset: 0})
+	assert.False(t, ok)
+}
+
+func TestValueCache_RemoveFile(t *testing.T) {
+	vc := NewValueCache(1024 * 1024)
+	for i := 0; i < 100; i++ {
+		vc.Put(&data.LogRecordPos{Fid: 1, Offset: int64(i)}, []byte("a"))
+		vc.Put(&data.LogRecordPos{Fid: 2, Offset: int64(i)}, []byte("b"))
+	}
+
+	vc.RemoveFile(1)
+	for i := 0; i < 100; i++ {
+		_, ok1 := vc.Get(&data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		assert.False(t, ok1)
+		val2, ok2 := vc.Get(&data.LogRecordPos{Fid: 2, Offset: int64(i)})
+		assert.True(t, ok2)
+		assert.Equal(t, []byte("b"), val2)
+	}
+	_, _, size := vc.Stats()
+	assert.Equal(t, int64(100*(1+entryOverhead)), size)
+}
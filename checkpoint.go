@@ -0,0 +1,113 @@
+package bitcaskgo
+
+import (
+	"bitcask-go/index"
+	"sync/atomic"
+	"time"
+)
+
+// B+ 树索引保存在磁盘上，和数据文件分别持久化。
+// 只有在数据文件已经持久化，并且之前写入的数据全部更新到索引之后，才能记录检查点，
+// 重新打开时索引会撤销检查点之后的修改，再从检查点开始重放数据文件。
+
+// beginIndexUpdate 开始写入数据文件之前调用，在访问此方法前必须持有互斥锁
+func (db *DB) beginIndexUpdate() {
+	atomic.AddInt64(&db.indexInflight, 1)
+}
+
+// abortIndexUpdate 写入数据文件失败时调用，在访问此方法前必须持有互斥锁
+func (db *DB) abortIndexUpdate() {
+	atomic.AddInt64(&db.indexInflight, -1)
+}
+
+// endIndexUpdate 更新完索引之后调用，如果有被推迟的检查点则尝试记录
+func (db *DB) endIndexUpdate() {
+	if atomic.AddInt64(&db.indexInflight, -1) > 0 || !db.checkpointDue.Load() {
+		return
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// 失败时保留被推迟的检查点，下次持久化时再重试
+	if err := db.tryCheckpoint(); err != nil {
+		db.logger.Warn("failed to save deferred checkpoint", "err", err)
+	}
+}
+
+// syncActiveFile 持久化活跃文件，并尝试为 B+ 树索引记录检查点
+// 在访问此方法前必须持有互斥锁
+func (db *DB) syncActiveFile() error {
+	start := time.Now()
+	err := db.activeFile.Sync()
+	db.onSync(SyncInfo{FileId: db.activeFile.FileId, Duration: time.Since(start), Err: err})
+	if err != nil {
+		return err
+	}
+	db.bytesWrite = 0
+	db.syncedFid, db.syncedOff = db.activeFile.FileId, db.activeFile.WriteOff
+	return db.tryCheckpoint()
+}
+
+// tryCheckpoint 活跃文件已经全部持久化时记录检查点，还有未完成的索引更新时推迟到更新完成之后
+// 在访问此方法前必须持有互斥锁
+func (db *DB) tryCheckpoint() error {
+	bpt, ok := db.index.(*index.BPlusTree)
+	if !ok || db.activeFile == nil {
+		return nil
+	}
+	// 持久化之后又有新的写入
+	if db.syncedFid != db.activeFile.FileId || db.syncedOff != db.activeFile.WriteOff {
+		return nil
+	}
+	if atomic.LoadInt64(&db.indexInflight) > 0 {
+		db.checkpointDue.Store(true)
+		return nil
+	}
+	// 索引中可能缺少检查点之前的修改
+	if err := db.indexErr(); err != nil {
+		return err
+	}
+	if err := bpt.SaveCheckpoint(&index.Checkpoint{
+		Fid:    db.activeFile.FileId,
+		Offset: db.activeFile.WriteOff,
+		SeqNo:  atomic.LoadUint64(&db.seqNo),
+		Meta:   db.fileStats.encode(),
+	}); err != nil {
+		return err
+	}
+	db.checkpointDue.Store(false)
+	return nil
+}
+
+// loadFileStatsFromCheckpoint 加载和 B+ 树索引的检查点一起保存的数据文件统计信息
+// 需要在应用 merge 结果之前调用，旧版本的检查点中没有统计信息，只会统计检查点之后的数据
+func (db *DB) loadFileStatsFromCheckpoint() error {
+	cp, err := db.index.(*index.BPlusTree).LoadCheckpoint()
+	if err != nil || cp == nil {
+		return err
+	}
+	db.loadFileStats(cp.Meta)
+	return nil
+}
+
+// loadIndexFromCheckpoint 从 B+ 树索引的检查点开始重放数据文件，没有检查点时重放所有数据文件
+func (db *DB) loadIndexFromCheckpoint() error {
+	bpt := db.index.(*index.BPlusTree)
+	cp, err := bpt.LoadCheckpoint()
+	if err != nil {
+		return err
+	}
+	if cp == nil {
+		return db.loadIndexFromDataFiles(0, 0)
+	}
+	if cp.SeqNo > db.seqNo {
+		db.seqNo = cp.SeqNo
+	}
+	if err := db.loadIndexFromDataFiles(cp.Fid, cp.Offset); err != nil {
+		return err
+	}
+	// 检查点之后有数据，说明上次没有正常关闭
+	if db.activeFile != nil && (db.activeFile.FileId != cp.Fid || db.activeFile.WriteOff != cp.Offset) {
+		db.onRecovery(RecoveryInfo{Action: RecoveryCheckpointReplay, FileId: cp.Fid, Offset: cp.Offset})
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package bitcaskgo
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 模拟进程崩溃，不持久化数据也不记录检查点，只释放打开的资源
+func crashDB(t *testing.T, db *DB) {
+	assert.Nil(t, db.index.Close())
+	assert.Nil(t, db.activeFile.Close())
+	for _, file := range db.olderFiles {
+		assert.Nil(t, file.Close())
+	}
+	assert.Nil(t, db.fileLock.Unlock())
+}
+
+func TestDB_BPTreeCheckpoint_LostData(t *testing.T) {
+	opts := DefaultOptions
+	opts.IndexType = BPTree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Sync())
+	syncedOff := db.activeFile.WriteOff
+
+	// 检查点之后的写入已经更新到索引，但是数据没有持久化
+	for i := 100; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Put(utils.GetTestKey(0), []byte("new value")))
+	assert.Nil(t, db.Delete(utils.GetTestKey(1)))
+	fileName := data.GetDataFileName(opts.DirPath, db.activeFile.FileId)
+	crashDB(t, db)
+	assert.Nil(t, os.Truncate(fileName, syncedOff))
+
+	// 索引撤销检查点之后的修改，不会指向丢失的数据
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(100), mustStat(t, db).KeyNum)
+	for i := 0; i < 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	_, err = db.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_BPTreeCheckpoint_Replay(t *testing.T) {
+	opts := DefaultOptions
+	opts.IndexType = BPTree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(0), utils.GetTestKey(0)))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.Sync())
+
+	// 检查点之后的数据保留在数据文件中，重新打开时重放
+	for i := 1; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Delete(utils.GetTestKey(0)))
+	assert.Nil(t, wb.Commit())
+	seqNo := db.seqNo
+	crashDB(t, db)
+
+	// 没有 seq-no 文件时也可以使用 WriteBatch
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, seqNo, db.seqNo)
+	assert.Equal(t, uint(99), mustStat(t, db).KeyNum)
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	for i := 1; i < 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(0), []byte("value")))
+	assert.Nil(t, wb.Commit())
+	val, err := db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+}
@@ -32,6 +32,15 @@ func OpenDataFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFi
 	return newDatafile(fileName, fileId, ioType)
 }
 
+// OpenCachedDataFile 打开旧的数据文件，文件描述符由 cache 按需打开和淘汰
+func OpenCachedDataFile(dirPath string, fileId uint32, cache *fio.FileCache) *DataFile {
+	return &DataFile{
+		FileId:    fileId,
+		WriteOff:  0,
+		IoManager: cache.Open(GetDataFileName(dirPath, fileId)),
+	}
+}
+
 // OpenHintFile 打开 Hint 文件
 func OpenHintFile(dirPath string) (*DataFile, error) {
 	fileNmae := filepath.Join(dirPath, HintFileName)
@@ -87,6 +96,7 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	if header == nil {
 		return nil, 0, io.EOF
 	}
+	// 全零的头部表示读到了预分配或未写入的区域，同样视为数据末尾
 	if header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
 		return nil, 0, io.EOF
 	}
@@ -95,7 +105,7 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
 	var recordSize = headerSize + keySize + valueSize
 
-	logRecord := &LogRecord{Type: header.recordType}
+	logRecord := &LogRecord{Type: header.recordType, Timestamp: header.timestamp}
 
 	// 开始读取用户实际存储的key/value 数据
 	if keySize > 0 || valueSize > 0 {
@@ -115,6 +125,38 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	return logRecord, recordSize, nil
 }
 
+// ReadLogRecords 一次读取从 offset 开始、总大小为 size 的多条连续的 LogRecord
+func (df *DataFile) ReadLogRecords(offset int64, size int64) ([]*LogRecord, error) {
+	buf, err := df.readNBytes(size, offset)
+	if err != nil {
+		return nil, err
+	}
+	var logRecords []*LogRecord
+	for start := int64(0); start < size; {
+		header, headerSize := decodeLogRecordHeader(buf[start:])
+		if header == nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		keyEnd := start + headerSize + int64(header.keySize)
+		valueEnd := keyEnd + int64(header.valueSize)
+		if valueEnd > size {
+			return nil, io.ErrUnexpectedEOF
+		}
+		logRecord := &LogRecord{
+			Key:       buf[start+headerSize : keyEnd : keyEnd],
+			Value:     buf[keyEnd:valueEnd:valueEnd],
+			Type:      header.recordType,
+			Timestamp: header.timestamp,
+		}
+		if crc := getLogRecordCRC(logRecord, buf[start+crc32.Size:start+headerSize]); crc != header.crc {
+			return nil, ErrInvalidCRC
+		}
+		logRecords = append(logRecords, logRecord)
+		start = valueEnd
+	}
+	return logRecords, nil
+}
+
 func (df *DataFile) Write(buf []byte) error {
 	n, err := df.IoManager.Write(buf)
 	if err != nil {
@@ -135,6 +177,16 @@ func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 	return df.Write(encRecord)
 }
 
+// Preallocate 为数据文件预分配 size 字节的磁盘空间，文件大小保持不变
+func (df *DataFile) Preallocate(size int64) error {
+	return df.IoManager.Preallocate(size)
+}
+
+// Trim 将数据文件截断到当前写入位置，丢弃末尾的无效数据并释放预分配的空间
+func (df *DataFile) Trim() error {
+	return df.IoManager.Truncate(df.WriteOff)
+}
+
 func (df *DataFile) Sync() error {
 	return df.IoManager.Sync()
 }
@@ -160,3 +212,12 @@ func (df *DataFile) SetIOManager(dirPath string, ioType fio.FileIOType) error {
 	df.IoManager = ioManager
 	return nil
 }
+
+// SetFileCache 关闭当前的文件，之后由 cache 管理数据文件的文件描述符
+func (df *DataFile) SetFileCache(dirPath string, cache *fio.FileCache) error {
+	if err := df.IoManager.Close(); err != nil {
+		return err
+	}
+	df.IoManager = cache.Open(GetDataFileName(dirPath, df.FileId))
+	return nil
+}
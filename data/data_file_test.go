@@ -2,6 +2,7 @@ package data
 
 import (
 	"bitcask-go/fio"
+	"io"
 	"os"
 	"testing"
 
@@ -107,3 +108,75 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	assert.Equal(t, size3, readSize3)
 	assert.Equal(t, rec3, readRec3)
 }
+
+func TestDataFile_ReadLogRecord_ZeroTail(t *testing.T) {
+	dataFile, err := OpenDataFile(os.TempDir(), 311, fio.StandardFile)
+	defer os.Remove(GetDataFileName(os.TempDir(), 311))
+	assert.Nil(t, err)
+	assert.NotNil(t, dataFile)
+
+	rec1 := &LogRecord{
+		Key:   []byte("name"),
+		Value: []byte("bitcask kv go"),
+	}
+	res1, size1 := EncodeLogRecord(rec1)
+	err = dataFile.Write(res1)
+	assert.Nil(t, err)
+	// 模拟预分配后残留的全零区域
+	err = dataFile.Write(make([]byte, 64))
+	assert.Nil(t, err)
+
+	readRec1, readSize1, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, size1, readSize1)
+	assert.Equal(t, rec1, readRec1)
+
+	_, _, err = dataFile.ReadLogRecord(size1)
+	assert.Equal(t, io.EOF, err)
+
+	// 截断到有效数据的末尾
+	dataFile.WriteOff = size1
+	err = dataFile.Trim()
+	assert.Nil(t, err)
+	size, err := dataFile.IoManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, size1, size)
+
+	err = dataFile.Close()
+	assert.Nil(t, err)
+}
+
+func TestDataFile_ReadLogRecords(t *testing.T) {
+	dataFile, err := OpenDataFile(os.TempDir(), 311, fio.StandardFile)
+	assert.Nil(t, err)
+	defer func() {
+		_ = dataFile.Close()
+		_ = os.Remove(GetDataFileName(os.TempDir(), 311))
+	}()
+
+	records := []*LogRecord{
+		{Key: []byte("name"), Value: []byte("bitcask kv go")},
+		{Key: []byte("name"), Value: []byte("a new value"), Timestamp: 1700000000000000000},
+		{Key: []byte("1"), Type: LogRecordDeleted},
+	}
+	var total int64
+	for _, rec := range records {
+		enc, size := EncodeLogRecord(rec)
+		assert.Nil(t, dataFile.Write(enc))
+		total += size
+	}
+
+	readRecs, err := dataFile.ReadLogRecords(0, total)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(readRecs))
+	for i, rec := range records {
+		assert.Equal(t, rec.Key, readRecs[i].Key)
+		assert.Equal(t, len(rec.Value), len(readRecs[i].Value))
+		assert.Equal(t, rec.Type, readRecs[i].Type)
+		assert.Equal(t, rec.Timestamp, readRecs[i].Timestamp)
+	}
+
+	// 范围没有在记录的边界上结束
+	_, err = dataFile.ReadLogRecords(0, total-1)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
@@ -13,16 +13,20 @@ const (
 	LogRecordTxnFinished
 )
 
-// crc type keySize valueSize
-// 4 + 1 + 5 + 5
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
+// crc type keySize valueSize timestamp
+// 4 + 1 + 5 + 5 + 10
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5 + binary.MaxVarintLen64
+
+// 类型字节的最高位标识头部是否带有写入时间戳，没有时间戳的旧数据仍然可以正常读取
+const logRecordTimestampFlag byte = 0x80
 
 // LogRecord 写入到数据文件的记录
 // 之所以叫日志，是因为数据文件中的数据是追加写入的，类似日志的格式
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key       []byte
+	Value     []byte
+	Type      LogRecordType
+	Timestamp int64 // 写入时间，Unix 纳秒时间戳，为 0 时不写入头部
 }
 
 // 头部信息
@@ -31,13 +35,16 @@ type logRecordHeader struct {
 	recordType LogRecordType // 标识 LogRecord 的类型
 	keySize    uint32        // key 的长度
 	valueSize  uint32        // value 的长度
+	timestamp  int64         // 写入时间，旧数据为 0
 }
 
 // LogRecordPos 数据内存索引，主要是描述数据在磁盘上的位置
 type LogRecordPos struct {
-	Fid    uint32 // 文件id，表示将数据存储到哪个文件当中
-	Offset int64  // 偏移，表示将数据存储到文件的哪个位置
-	Size   uint32 // 标识数据在磁盘上的大小
+	Fid       uint32 // 文件id，表示将数据存储到哪个文件当中
+	Offset    int64  // 偏移，表示将数据存储到文件的哪个位置
+	Size      uint32 // 标识数据在磁盘上的大小
+	ValueSize uint32 // value 的长度
+	Timestamp int64  // 数据的写入时间，Unix 纳秒时间戳，没有记录时间的旧数据为 0
 }
 
 // TransactionRecord 暂存的事务相关的数据
@@ -52,10 +59,16 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	header := make([]byte, maxLogRecordHeaderSize)
 
 	header[4] = logRecord.Type
+	if logRecord.Timestamp != 0 {
+		header[4] |= logRecordTimestampFlag
+	}
 	var index = 5
-	// 5 字节之后，存储的是 key 和 value 的长度
+	// 5 字节之后，存储的是 key 和 value 的长度，以及可选的写入时间
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
+	if logRecord.Timestamp != 0 {
+		index += binary.PutVarint(header[index:], logRecord.Timestamp)
+	}
 
 	var size = index + len(logRecord.Key) + len(logRecord.Value)
 
@@ -77,11 +90,13 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 }
 
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen32*3+binary.MaxVarintLen64*2)
 	var index = 0
 	index += binary.PutVarint(buf[index:], int64(pos.Fid))
 	index += binary.PutVarint(buf[index:], pos.Offset)
 	index += binary.PutVarint(buf[index:], int64(pos.Size))
+	index += binary.PutVarint(buf[index:], int64(pos.ValueSize))
+	index += binary.PutVarint(buf[index:], pos.Timestamp)
 	return buf[:index]
 }
 
@@ -91,12 +106,21 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	index += n
 	offset, n := binary.Varint(buf[index:])
 	index += n
-	size, _ := binary.Varint(buf[index:])
-	return &LogRecordPos{
+	size, n := binary.Varint(buf[index:])
+	index += n
+	pos := &LogRecordPos{
 		Fid:    uint32(fileId),
 		Offset: offset,
 		Size:   uint32(size),
 	}
+	// 旧版本编码的位置信息中没有 value 长度和写入时间
+	if index < len(buf) {
+		valueSize, n := binary.Varint(buf[index:])
+		index += n
+		timestamp, _ := binary.Varint(buf[index:])
+		pos.ValueSize, pos.Timestamp = uint32(valueSize), timestamp
+	}
+	return pos
 }
 
 func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
@@ -106,7 +130,7 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 
 	header := &logRecordHeader{
 		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		recordType: buf[4],
+		recordType: buf[4] &^ logRecordTimestampFlag,
 	}
 
 	var index = 5
@@ -118,6 +142,12 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 	valueSize, n := binary.Varint(buf[index:])
 	header.valueSize = uint32(valueSize)
 	index += n
+
+	if buf[4]&logRecordTimestampFlag != 0 {
+		timestamp, n := binary.Varint(buf[index:])
+		header.timestamp = timestamp
+		index += n
+	}
 	return header, int64(index)
 }
 
@@ -95,3 +95,33 @@ func TestGetLogRecordCRC(t *testing.T) {
 	assert.Equal(t, uint32(290887979), crc)
 
 }
+
+func TestEncodeLogRecord_Timestamp(t *testing.T) {
+	rec := &LogRecord{
+		Key:       []byte("name"),
+		Value:     []byte("bitcask-go"),
+		Type:      LogRecordDeleted,
+		Timestamp: 1700000000000000000,
+	}
+	res, n := EncodeLogRecord(rec)
+	h, size := decodeLogRecordHeader(res)
+	assert.Equal(t, LogRecordDeleted, h.recordType)
+	assert.Equal(t, rec.Timestamp, h.timestamp)
+	assert.Equal(t, uint32(4), h.keySize)
+	assert.Equal(t, uint32(10), h.valueSize)
+	assert.Equal(t, n, size+14)
+
+	// 没有时间戳的数据编码格式保持不变
+	rec.Timestamp = 0
+	res, _ = EncodeLogRecord(rec)
+	assert.Equal(t, []byte{43, 153, 86, 17, 1, 8, 20}, res[:7])
+}
+
+func TestDecodeLogRecordPos(t *testing.T) {
+	pos := &LogRecordPos{Fid: 1, Offset: 100, Size: 20, ValueSize: 10, Timestamp: 1700000000000000000}
+	assert.Equal(t, pos, DecodeLogRecordPos(EncodeLogRecordPos(pos)))
+
+	// 旧版本编码的位置信息只有文件 id、偏移和大小
+	oldBuf := []byte{2, 200, 1, 40}
+	assert.Equal(t, &LogRecordPos{Fid: 1, Offset: 100, Size: 20}, DecodeLogRecordPos(oldBuf))
+}
@@ -1,6 +1,8 @@
 package bitcaskgo
 
 import (
+	"bitcask-go/bloom"
+	"bitcask-go/cache"
 	"bitcask-go/data"
 	"bitcask-go/fio"
 	"bitcask-go/index"
@@ -8,12 +10,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofrs/flock"
 )
@@ -39,18 +45,40 @@ type DB struct {
 	fileLock        *flock.Flock              // 文件锁保证多进程之间的互斥
 	bytesWrite      uint                      //累计写了多少个字节
 	reclaimSize     int64                     // 表示有多少数据是无效的
+	fileCache       *fio.FileCache            // 旧数据文件的文件描述符缓存，不限制打开文件数时为 nil
+	valueCache      *cache.ValueCache         // value 读缓存，未开启时为 nil
+	keyLocks        *keyLocks                 // 按 key 分段的写锁，更新索引时不需要持有全局锁
+	rateLimiter     *fio.RateLimiter          // merge、备份和重建索引等后台任务的读写限速器
+	writeLimiter    *fio.RateLimiter          // 写入活跃文件时的限速器，仅用于 merge 时的临时数据库
+	indexInflight   int64                     // 已经开始写入数据文件但还没有更新完索引的写操作数量
+	checkpointDue   atomic.Bool               // 是否有因为索引更新未完成而推迟的检查点
+	syncedFid       uint32                    // 最近一次持久化时活跃文件的 id
+	syncedOff       int64                     // 最近一次持久化时活跃文件的写入偏移
+	bloomFilter     *bloom.Filter             // B+ 树索引的布隆过滤器，未开启时为 nil
+	watchHub        *watchHub                 // 按写入顺序向订阅者发布变更
+	metrics         *engineMetrics            // 运行时指标
+	fileStats       *fileStats                // 每个数据文件的统计信息
+	logger          *slog.Logger              // 结构化日志
+	listener        EventListener             // 内部事件的回调
 }
 
 // Stat 表示数据库的统计信息。
 type Stat struct {
-	KeyNum          uint  // 数据库中键的数量
-	DataFileNum     uint  // 数据文件的数量
-	ReclaimableSize int64 // 可回收的数据大小,以字节为单位
-	DiskSize        int64 // 数据库在磁盘上占用的总大小,以字节为单位
+	KeyNum          uint   // 数据库中键的数量
+	DataFileNum     uint   // 数据文件的数量
+	ReclaimableSize int64  // 可回收的数据大小,以字节为单位
+	DiskSize        int64  // 数据库在磁盘上占用的总大小,以字节为单位
+	FileCacheHits   uint64 // 读取旧数据文件时文件已打开的次数
+	FileCacheMisses uint64 // 读取旧数据文件时需要重新打开文件的次数
+	CacheHits       uint64 // 读取 value 时命中缓存的次数
+	CacheMisses     uint64 // 读取 value 时未命中缓存的次数
+	CacheSize       int64  // value 缓存占用的内存大小,以字节为单位
+	IndexMemSize    int64  // 内存索引占用的内存大小,以字节为单位,不支持统计的索引类型为 0
+	BloomFilterSize int64  // 布隆过滤器占用的内存大小,以字节为单位
 }
 
 // Open 打开 bitcask 存储引擎实例
-func Open(options Options) (*DB, error) {
+func Open(options Options) (_ *DB, err error) {
 	// 对用户传入的配置项进行校验
 	if err := checkOptions(options); err != nil {
 		return nil, err
@@ -75,6 +103,13 @@ func Open(options Options) (*DB, error) {
 	if !hold {
 		return nil, ErrDatabaseIsUsing
 	}
+	// 打开失败时关闭已经打开的索引和数据文件，并释放目录锁
+	var db *DB
+	defer func() {
+		if err != nil {
+			releaseOnOpenFailure(db, fileLock)
+		}
+	}()
 
 	entries, err := os.ReadDir(options.DirPath)
 	if err != nil {
@@ -85,13 +120,31 @@ func Open(options Options) (*DB, error) {
 	}
 
 	// 初始化 DB 实例结构体
-	db := &DB{
-		options:    options,
-		mu:         new(sync.RWMutex),
-		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
-		isInitial:  isInitial,
-		fileLock:   fileLock,
+	db = &DB{
+		options:     options,
+		mu:          new(sync.RWMutex),
+		olderFiles:  make(map[uint32]*data.DataFile),
+		isInitial:   isInitial,
+		fileLock:    fileLock,
+		keyLocks:    newKeyLocks(),
+		fileStats:   newFileStats(),
+		rateLimiter: fio.NewRateLimiter(options.BackgroundIORate),
+	}
+	db.initObservability()
+	indexer, err := index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites, options.Comparator)
+	if err != nil {
+		return nil, err
+	}
+	db.index = indexer
+	if options.CacheSize > 0 {
+		db.valueCache = cache.NewValueCache(options.CacheSize)
+	}
+
+	// B+ 树索引的统计信息需要在应用 merge 结果之前加载
+	if options.IndexType == BPTree {
+		if err := db.loadFileStatsFromCheckpoint(); err != nil {
+			return nil, err
+		}
 	}
 
 	// load merge data files
@@ -111,51 +164,96 @@ func Open(options Options) (*DB, error) {
 			return nil, err
 		}
 		// 从数据文件中加载索引
-		if err := db.loadIndexFromDataFiles(); err != nil {
+		if err := db.loadIndexFromDataFiles(0, 0); err != nil {
 			return nil, err
 		}
+	} else {
+		// load seq no
+		if err := db.loadSeqNo(); err != nil {
+			return nil, err
+		}
+		// B+ 树索引保存在磁盘上，只需要重放检查点之后的数据
+		if err := db.loadIndexFromCheckpoint(); err != nil {
+			return nil, err
+		}
+		db.initBloomFilter()
+		if err := db.indexErr(); err != nil {
+			return nil, err
+		}
+	}
 
-		// 重置 IO 类型 为标准IO
-		if db.options.MMapAtStartup {
-			if err := db.resetIOType(); err != nil {
-				return nil, err
-			}
+	// 重置 IO 类型 为标准IO
+	if db.options.MMapAtStartup {
+		if err := db.resetIOType(); err != nil {
+			return nil, err
 		}
 	}
-	// load seq no
-	if options.IndexType == BPTree {
-		if err := db.loadSeqNo(); err != nil {
+
+	// 活跃文件末尾可能残留预分配的空间或未写完的记录，截断到最后一条有效记录
+	if db.activeFile != nil {
+		fileSize, err := db.activeFile.IoManager.Size()
+		if err != nil {
 			return nil, err
 		}
-		if db.activeFile != nil {
-			size, err := db.activeFile.IoManager.Size()
-			if err != nil {
+		if err := db.activeFile.Trim(); err != nil {
+			return nil, err
+		}
+		// 预分配的空间是正常关闭时留下的，不算作恢复操作
+		if fileSize > db.activeFile.WriteOff && !options.PreallocateDataFile {
+			db.onRecovery(RecoveryInfo{
+				Action: RecoveryTailTruncated,
+				FileId: db.activeFile.FileId,
+				Offset: db.activeFile.WriteOff,
+				Count:  int(fileSize - db.activeFile.WriteOff),
+			})
+		}
+		// 重放的数据持久化之后记录新的检查点
+		if options.IndexType == BPTree {
+			if err := db.syncActiveFile(); err != nil {
 				return nil, err
 			}
-			db.activeFile.WriteOff = size
 		}
 	}
+	db.watchHub = newWatchHub(db.currentSeq())
+	db.logger.Info("database opened", "files", len(db.fileIds), "index", options.IndexType)
 	return db, nil
 }
 
+// releaseOnOpenFailure 关闭打开失败的数据库已经打开的资源，db 为空时只释放目录锁
+// 返回给调用方的是打开失败的原因，这里的错误只记录日志
+func releaseOnOpenFailure(db *DB, fileLock *flock.Flock) {
+	var errs []error
+	if db != nil {
+		if db.index != nil {
+			errs = append(errs, db.index.Close())
+		}
+		if db.activeFile != nil {
+			errs = append(errs, db.activeFile.Close())
+		}
+		for _, file := range db.olderFiles {
+			errs = append(errs, file.Close())
+		}
+	}
+	errs = append(errs, fileLock.Unlock(), fileLock.Close())
+	if err := errors.Join(errs...); err != nil && db != nil {
+		db.logger.Warn("failed to release resources after open failure", "err", err)
+	}
+}
+
 // close database
-func (db *DB) Close() error {
+func (db *DB) Close() (err error) {
 	defer func() {
-		if err := db.fileLock.Unlock(); err != nil {
-			panic(fmt.Sprintf("failed to unlock the directory, %v", err))
+		if unlockErr := db.fileLock.Unlock(); unlockErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to unlock the directory: %w", unlockErr))
 		}
-		if err := db.fileLock.Close(); err != nil {
-			panic(fmt.Sprintf("failed to close the directory lock, %v", err))
+		if closeErr := db.fileLock.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close the directory lock: %w", closeErr))
 		}
 	}()
+	db.watchHub.closeAll()
 
-	err := db.index.Close()
-	if err != nil {
-		return err
-	}
 	if db.activeFile == nil {
-
-		return nil
+		return db.index.Close()
 	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
@@ -178,6 +276,17 @@ func (db *DB) Close() error {
 	if err := seqNoFile.Close(); err != nil {
 		return err
 	}
+	if err := db.syncActiveFile(); err != nil {
+		return err
+	}
+	if err := db.index.Close(); err != nil {
+		return err
+	}
+	if db.options.PreallocateDataFile {
+		if err := db.activeFile.Trim(); err != nil {
+			return err
+		}
+	}
 	if err := db.activeFile.Close(); err != nil {
 		return err
 	}
@@ -197,11 +306,11 @@ func (db *DB) Sync() error {
 	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	return db.activeFile.Sync()
+	return db.syncActiveFile()
 }
 
 // 返回数据库的统计信息
-func (db *DB) Stat() *Stat {
+func (db *DB) Stat() (*Stat, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 	var dataFiles = uint(len(db.olderFiles))
@@ -210,14 +319,30 @@ func (db *DB) Stat() *Stat {
 	}
 	dirSize, err := utils.DirSize(db.options.DirPath)
 	if err != nil {
-		panic(fmt.Sprintf("failed to get dir size, %v", err))
+		return nil, fmt.Errorf("failed to get dir size: %w", err)
 	}
-	return &Stat{
+	stat := &Stat{
 		KeyNum:          uint(db.index.Size()),
 		DataFileNum:     dataFiles,
-		ReclaimableSize: db.reclaimSize,
+		ReclaimableSize: atomic.LoadInt64(&db.reclaimSize),
 		DiskSize:        dirSize,
 	}
+	if sizer, ok := db.index.(index.MemSizer); ok {
+		stat.IndexMemSize = sizer.MemSize()
+	}
+	if db.fileCache != nil {
+		stat.FileCacheHits, stat.FileCacheMisses = db.fileCache.Stats()
+	}
+	if db.valueCache != nil {
+		stat.CacheHits, stat.CacheMisses, stat.CacheSize = db.valueCache.Stats()
+	}
+	if db.bloomFilter != nil {
+		stat.BloomFilterSize = db.bloomFilter.MemSize()
+	}
+	if err := db.indexErr(); err != nil {
+		return nil, err
+	}
+	return stat, nil
 }
 
 // 备份数据库, 备份到指定目录
@@ -225,78 +350,117 @@ func (db *DB) Backup(dir string) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName})
+	ioType := fio.StandardFile
+	if db.options.DirectIO&DirectIOBackup != 0 {
+		ioType = fio.DirectIO
+	}
+	readFile := func(name string) ([]byte, error) {
+		return fio.ReadFile(name, ioType, db.rateLimiter)
+	}
+	return utils.CopyDirWithReader(db.options.DirPath, dir, []string{fileLockName}, readFile)
+}
+
+// SetBackgroundIORate 调整 merge、备份和重建索引等后台任务每秒读写的字节数，小于等于 0 表示不限速
+func (db *DB) SetBackgroundIORate(bytesPerSec int64) {
+	db.rateLimiter.SetRate(bytesPerSec)
 }
 
 // 写入 Key/Value 数据，key 不能为空，否则返回错误。
-func (db *DB) Put(key []byte, value []byte) error {
+func (db *DB) Put(key []byte, value []byte) (err error) {
 	// 判断key是否有效
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	start := time.Now()
+	defer func() { db.metrics.observe(&db.metrics.puts, db.metrics.writeLatency, start, err) }()
 	// 构造 LogRecord 结构体
 	log_record := &data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:       logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:     value,
+		Type:      data.LogRecordNormal,
+		Timestamp: time.Now().UnixNano(),
 	}
 
+	// 持有 key 所在分段的锁，保证索引的更新顺序和写入顺序一致
+	unlock := db.keyLocks.lock(key)
+	defer unlock()
+
 	// 追加写入到当前活跃数据文件当中
-	pos, err := db.appendLogRecordWithLock(log_record)
+	pos, ticket, err := db.appendLogRecordWithLock(log_record)
 	if err != nil {
 		return err
 	}
+	defer db.endIndexUpdate()
 
+	db.addToBloomFilter(key)
 	if oldPos := db.index.Put(key, pos); oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+		db.markStale(oldPos)
 	}
+	// 数据已经写入数据文件，索引失败时也需要发布，重新打开后会从数据文件中恢复
+	db.publishWrite(ticket, pos, EventPut, key, value)
 
-	return nil
+	return db.indexErr()
 }
 
 // Delete 根据 key 删除对应的数据
-func (db *DB) Delete(key []byte) error {
+func (db *DB) Delete(key []byte) (err error) {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	start := time.Now()
+	defer func() { db.metrics.observe(&db.metrics.deletes, db.metrics.writeLatency, start, err) }()
 
-	if pos := db.index.Get(key); pos == nil {
-		return nil
+	unlock := db.keyLocks.lock(key)
+	defer unlock()
+
+	if pos, err := db.getIndex(key); err != nil || pos == nil {
+		return err
 	}
 
 	// 构造 LogRecord, 标识其被删除
 	logRecord := &data.LogRecord{
-		Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Type: data.LogRecordDeleted,
+		Key:       logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Type:      data.LogRecordDeleted,
+		Timestamp: time.Now().UnixNano(),
 	}
 	// 写入到数据文件当中
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, ticket, err := db.appendLogRecordWithLock(logRecord)
 	if err != nil {
 		return err
 	}
-	db.reclaimSize += int64(pos.Size)
+	defer db.endIndexUpdate()
+	// 删除已经写入数据文件，无论索引是否更新成功都需要发布
+	defer db.publishWrite(ticket, pos, EventDelete, key, nil)
 	// 从内存索引中将对应的 key 删除
 	oldPos, ok := db.index.Delete(key)
+	if err := db.indexErr(); err != nil {
+		return err
+	}
 	if !ok {
 		return ErrIndexUpdateFailed
 	}
 	if oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+		db.markStale(oldPos)
 	}
 	return nil
 }
 
 // Get 读取 Key 对应的数据
-func (db *DB) Get(key []byte) ([]byte, error) {
+func (db *DB) Get(key []byte) (value []byte, err error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 	// 判断key是否有效
 	if len(key) == 0 {
 		return nil, ErrKeyIsEmpty
 	}
+	start := time.Now()
+	defer func() { db.metrics.observe(&db.metrics.gets, db.metrics.readLatency, start, err) }()
 
 	// 从内存数据结构中取出 key 对应的索引信息
-	logRecordPos := db.index.Get(key)
+	logRecordPos, err := db.getIndex(key)
+	if err != nil {
+		return nil, err
+	}
 	// 如果索引信息为空，则表示 key 不存在
 	if logRecordPos == nil {
 		return nil, ErrKeyNotFound
@@ -306,9 +470,110 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	return db.getValueByPosition(logRecordPos)
 }
 
+// MultiGet 批量读取多个 key 对应的数据，返回的 value 和错误与 keys 一一对应
+// 在一次加锁中查询所有 key 的索引，再按照数据文件和偏移合并读取
+func (db *DB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+	// 按照每个 key 的结果计数，空 key 不计入
+	defer func() {
+		for _, err := range errs {
+			if err != ErrKeyIsEmpty {
+				db.metrics.count(&db.metrics.gets, err)
+			}
+		}
+	}()
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var found []int
+	var positions []*data.LogRecordPos
+	for i, key := range keys {
+		if len(key) == 0 {
+			errs[i] = ErrKeyIsEmpty
+			continue
+		}
+		logRecordPos, err := db.getIndex(key)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if logRecordPos == nil {
+			errs[i] = ErrKeyNotFound
+			continue
+		}
+		found = append(found, i)
+		positions = append(positions, logRecordPos)
+	}
+	if len(positions) == 0 {
+		return values, errs
+	}
+
+	foundValues, foundErrs := db.readValues(positions)
+	for j, i := range found {
+		values[i], errs[i] = foundValues[j], foundErrs[j]
+	}
+	return values, errs
+}
+
+// ValueSize 返回 key 对应的 value 的长度，只需要读取索引
+func (db *DB) ValueSize(key []byte) (int, error) {
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+	logRecordPos, err := db.getIndex(key)
+	if err != nil {
+		return 0, err
+	}
+	if logRecordPos == nil {
+		return 0, ErrKeyNotFound
+	}
+	return db.valueSizeByPosition(logRecordPos)
+}
+
+// ModTime 返回 key 最后一次写入的时间，只需要读取索引
+// 没有记录写入时间的旧数据返回零值
+func (db *DB) ModTime(key []byte) (time.Time, error) {
+	if len(key) == 0 {
+		return time.Time{}, ErrKeyIsEmpty
+	}
+	logRecordPos, err := db.getIndex(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if logRecordPos == nil {
+		return time.Time{}, ErrKeyNotFound
+	}
+	return modTimeByPosition(logRecordPos), nil
+}
+
+// valueSizeByPosition 根据索引信息获取 value 的长度
+// 旧版本的 hint 文件和 B+ 树索引中没有 value 长度，这类数据需要读取数据文件
+func (db *DB) valueSizeByPosition(logRecordPos *data.LogRecordPos) (int, error) {
+	if logRecordPos.ValueSize > 0 || logRecordPos.Timestamp != 0 {
+		return int(logRecordPos.ValueSize), nil
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	value, err := db.getValueByPosition(logRecordPos)
+	if err != nil {
+		return 0, err
+	}
+	return len(value), nil
+}
+
+func modTimeByPosition(logRecordPos *data.LogRecordPos) time.Time {
+	if logRecordPos.Timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, logRecordPos.Timestamp)
+}
+
 // 获取数据库中所有的key
 func (db *DB) ListKey() [][]byte {
 	iterator := db.index.Iterator(false)
+	defer iterator.Close()
 	keys := make([][]byte, db.index.Size())
 	var idx int = 0
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
@@ -324,20 +589,39 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 	defer db.mu.RUnlock()
 
 	iterator := db.index.Iterator(false)
-	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		value, err := db.getValueByPosition(iterator.Value())
-		if err != nil {
-			return err
+	defer iterator.Close()
+	// 每次预读一批 value，相邻的数据合并读取
+	keys := make([][]byte, 0, foldPrefetchSize)
+	positions := make([]*data.LogRecordPos, 0, foldPrefetchSize)
+	for iterator.Rewind(); iterator.Valid(); {
+		keys, positions = keys[:0], positions[:0]
+		for ; iterator.Valid() && len(keys) < foldPrefetchSize; iterator.Next() {
+			keys = append(keys, iterator.Key())
+			positions = append(positions, iterator.Value())
 		}
-		if !fn(iterator.Key(), value) {
-			break
+		values, errs := db.readValues(positions)
+		for i, key := range keys {
+			if errs[i] != nil {
+				return errs[i]
+			}
+			if !fn(key, values[i]) {
+				return nil
+			}
 		}
 	}
-	return nil
+	// 索引发生错误时迭代器会提前结束
+	return db.indexErr()
 }
 
 // 根据索引信息获取value
 func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	// 优先从缓存中读取
+	if db.valueCache != nil {
+		if value, ok := db.valueCache.Get(logRecordPos); ok {
+			return value, nil
+		}
+	}
+
 	// 根据文件 id 找到对应的数据文件
 	var dataFile *data.DataFile
 	if db.activeFile.FileId == logRecordPos.Fid {
@@ -353,19 +637,30 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 	// 根据偏移读取对应的数据
 	logRecord, _, err := dataFile.ReadLogRecord(logRecordPos.Offset)
 	if err != nil {
+		db.reportCorruption(logRecordPos.Fid, logRecordPos.Offset, err)
 		return nil, err
 	}
 
 	if logRecord.Type == data.LogRecordDeleted {
 		return nil, ErrKeyNotFound
 	}
+	if db.valueCache != nil {
+		db.valueCache.Put(logRecordPos, logRecord.Value)
+	}
 	return logRecord.Value, nil
 }
 
-func (db *DB) appendLogRecordWithLock(LogRecord *data.LogRecord) (*data.LogRecordPos, error) {
+// 写入成功后调用方更新完索引需要调用 endIndexUpdate，并用返回的发布序号发布变更
+func (db *DB) appendLogRecordWithLock(LogRecord *data.LogRecord) (*data.LogRecordPos, uint64, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	return db.appendLogRecord(LogRecord)
+	db.beginIndexUpdate()
+	pos, err := db.appendLogRecord(LogRecord)
+	if err != nil {
+		db.abortIndexUpdate()
+		return nil, 0, err
+	}
+	return pos, db.watchHub.reserve(), nil
 }
 
 // 追加写入到当前活跃数据文件当中
@@ -383,16 +678,7 @@ func (db *DB) appendLogRecord(LogRecord *data.LogRecord) (*data.LogRecordPos, er
 	encRecord, size := data.EncodeLogRecord(LogRecord)
 	// 如果写入的数据已经打到了活跃文件的阈值，则关闭当前活跃文件，打开新的文件
 	if db.activeFile.WriteOff+int64(size) > db.options.DataFileSize {
-		// 先持久化数据文件，保证已有的数据持久到磁盘当中
-		if err := db.activeFile.Sync(); err != nil {
-			return nil, err
-		}
-
-		// 当前活跃文件转化为旧的数据文件
-		db.olderFiles[db.activeFile.FileId] = db.activeFile
-
-		// 打开新的数据文件
-		if err := db.setActiveDataFile(); err != nil {
+		if err := db.rotateActiveFile(); err != nil {
 			return nil, err
 		}
 	}
@@ -401,6 +687,7 @@ func (db *DB) appendLogRecord(LogRecord *data.LogRecord) (*data.LogRecordPos, er
 	if err := db.activeFile.Write(encRecord); err != nil {
 		return nil, err
 	}
+	db.metrics.bytesWritten.Add(uint64(size))
 
 	db.bytesWrite += uint(size)
 	// 根据用户配置决定是否持久化
@@ -409,23 +696,62 @@ func (db *DB) appendLogRecord(LogRecord *data.LogRecord) (*data.LogRecordPos, er
 		needSync = true
 	}
 	if needSync {
-		if err := db.activeFile.Sync(); err != nil {
+		if err := db.syncActiveFile(); err != nil {
 			return nil, err
 		}
-		if db.bytesWrite > 0 {
-			db.bytesWrite = 0
-		}
 	}
 
 	// 构造位置索引
 	pos := &data.LogRecordPos{
-		Fid:    db.activeFile.FileId,
-		Offset: wirteOff,
-		Size:   uint32(size),
+		Fid:       db.activeFile.FileId,
+		Offset:    wirteOff,
+		Size:      uint32(size),
+		ValueSize: uint32(len(LogRecord.Value)),
+		Timestamp: LogRecord.Timestamp,
 	}
+	db.accountRecord(pos, LogRecord.Type)
 	return pos, nil
 }
 
+// rotateActiveFile 当前活跃文件转化为旧的数据文件，并打开新的活跃文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) rotateActiveFile() error {
+	oldFid, oldSize := db.activeFile.FileId, db.activeFile.WriteOff
+	if err := db.retireActiveFile(); err != nil {
+		return err
+	}
+	if err := db.setActiveDataFile(); err != nil {
+		return err
+	}
+	db.onFileRotated(FileRotatedInfo{OldFileId: oldFid, OldFileSize: oldSize, NewFileId: db.activeFile.FileId})
+	return nil
+}
+
+// retireActiveFile 将当前活跃文件转化为旧的数据文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) retireActiveFile() error {
+	// 先持久化数据文件，保证已有的数据持久到磁盘当中
+	if err := db.syncActiveFile(); err != nil {
+		return err
+	}
+
+	// 释放活跃文件中预分配但未使用的空间
+	if db.options.PreallocateDataFile {
+		if err := db.activeFile.Trim(); err != nil {
+			return err
+		}
+	}
+
+	// 旧的数据文件交由文件描述符缓存管理
+	if db.fileCache != nil {
+		if err := db.activeFile.SetFileCache(db.options.DirPath, db.fileCache); err != nil {
+			return err
+		}
+	}
+	db.olderFiles[db.activeFile.FileId] = db.activeFile
+	return nil
+}
+
 // setActiveDataFile 设置当前活跃文件
 // 在访问此方法前必须持有互斥锁
 func (db *DB) setActiveDataFile() error {
@@ -434,10 +760,18 @@ func (db *DB) setActiveDataFile() error {
 		initialFileId = db.activeFile.FileId + 1
 	}
 	// 打开新的活跃文件
-	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId, fio.StandardFile)
+	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId, db.dataFileIOType())
 	if err != nil {
 		return err
 	}
+	if db.options.PreallocateDataFile {
+		if err := dataFile.Preallocate(db.options.DataFileSize); err != nil {
+			return err
+		}
+	}
+	if db.writeLimiter != nil {
+		dataFile.IoManager = fio.NewRateLimitedIOManager(dataFile.IoManager, db.writeLimiter)
+	}
 	db.activeFile = dataFile
 	return nil
 }
@@ -467,11 +801,21 @@ func (db *DB) loadDataFiles() error {
 	//对文件id进行排序，从小到大依次加载文件
 	sort.Ints(fileIds)
 	db.fileIds = fileIds
+
+	ioType := db.dataFileIOType()
+	if db.options.MMapAtStartup {
+		ioType = fio.MemoryMap
+	}
+	// 限制了打开文件数时，旧的数据文件在读取时才会打开
+	if db.options.MaxOpenFiles > 0 {
+		db.fileCache = fio.NewFileCache(db.options.MaxOpenFiles, ioType)
+	}
+
 	//遍历每个文件id，打开对应的数据文件
 	for i, fid := range fileIds {
-		ioType := fio.StandardFile
-		if db.options.MMapAtStartup {
-			ioType = fio.MemoryMap
+		if i < len(fileIds)-1 && db.fileCache != nil {
+			db.olderFiles[uint32(fid)] = data.OpenCachedDataFile(db.options.DirPath, uint32(fid), db.fileCache)
+			continue
 		}
 		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), ioType)
 		if err != nil {
@@ -488,7 +832,8 @@ func (db *DB) loadDataFiles() error {
 
 // 从数据文件中加载索引
 // 遍历文件中的所有记录，并更新到内存索引中
-func (db *DB) loadIndexFromDataFiles() error {
+// 从 startFid 文件的 startOffset 位置开始加载，之前的数据已经在索引中
+func (db *DB) loadIndexFromDataFiles(startFid uint32, startOffset int64) error {
 	if len(db.fileIds) == 0 {
 		return nil
 	}
@@ -509,12 +854,28 @@ func (db *DB) loadIndexFromDataFiles() error {
 		var oldPos *data.LogRecordPos
 		if typ == data.LogRecordDeleted {
 			oldPos, _ = db.index.Delete(key)
-			db.reclaimSize += int64(pos.Size)
 		} else {
 			oldPos = db.index.Put(key, pos)
 		}
 		if oldPos != nil {
-			db.reclaimSize += int64(oldPos.Size)
+			db.markStale(oldPos)
+		}
+	}
+
+	// 事务中的数据批量更新到索引中
+	applyTransaction := func(records []*data.TransactionRecord) {
+		ops := make([]index.BatchOp, len(records))
+		for i, txnRecord := range records {
+			ops[i] = index.BatchOp{
+				Key:    txnRecord.Record.Key,
+				Pos:    txnRecord.Pos,
+				Delete: txnRecord.Record.Type == data.LogRecordDeleted,
+			}
+		}
+		for _, oldPos := range db.index.ApplyBatch(ops) {
+			if oldPos != nil {
+				db.markStale(oldPos)
+			}
 		}
 	}
 
@@ -528,30 +889,43 @@ func (db *DB) loadIndexFromDataFiles() error {
 		if hasMerge && fileId < nonMergeFileId {
 			continue
 		}
+		if fileId < startFid {
+			continue
+		}
 		var dataFile *data.DataFile
 		if fileId == db.activeFile.FileId {
 			dataFile = db.activeFile
 		} else {
 			dataFile = db.olderFiles[fileId]
 		}
+		// 重建索引时限制读取速率
+		dataFile = db.rateLimitedDataFile(dataFile)
 
 		var offset int64 = 0
+		if fileId == startFid {
+			offset = startOffset
+		}
 		for {
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
 				if err == io.EOF {
 					break
 				}
+				db.reportCorruption(fileId, offset, err)
 				return err
 			}
 
 			// 构建内存索引并保存
 			logRecordPos := &data.LogRecordPos{
-				Fid:    fileId,
-				Offset: offset,
-				Size:   uint32(size),
+				Fid:       fileId,
+				Offset:    offset,
+				Size:      uint32(size),
+				ValueSize: uint32(len(logRecord.Value)),
+				Timestamp: logRecord.Timestamp,
 			}
 
+			db.accountRecord(logRecordPos, logRecord.Type)
+
 			// 解析key，拿到事务序列号
 			realKey, seqNo := parseLogRecordKey(logRecord.Key)
 			if seqNo == nonTransactionSeqNo {
@@ -560,9 +934,7 @@ func (db *DB) loadIndexFromDataFiles() error {
 			} else {
 				// 事务完成，对应的 seq no 的数据可以更新到内存索引中
 				if logRecord.Type == data.LogRecordTxnFinished {
-					for _, txnRecord := range transactionRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
-					}
+					applyTransaction(transactionRecords[seqNo])
 					delete(transactionRecords, seqNo)
 				} else {
 					logRecord.Key = realKey
@@ -586,9 +958,25 @@ func (db *DB) loadIndexFromDataFiles() error {
 		}
 	}
 
+	// 没有读到完成标识的事务不会生效，其中的数据都是无效数据
+	for _, records := range transactionRecords {
+		for _, txnRecord := range records {
+			db.markStale(txnRecord.Pos)
+		}
+	}
+	if len(transactionRecords) > 0 {
+		db.onRecovery(RecoveryInfo{
+			Action: RecoveryTxnDiscarded,
+			FileId: db.activeFile.FileId,
+			Count:  len(transactionRecords),
+		})
+	}
+
 	// 更新事务序列号
-	db.seqNo = currentSeqNo
-	return nil
+	if currentSeqNo > db.seqNo {
+		db.seqNo = currentSeqNo
+	}
+	return db.indexErr()
 }
 func checkOptions(options Options) error {
 	if options.DirPath == "" {
@@ -597,9 +985,27 @@ func checkOptions(options Options) error {
 	if options.DataFileSize <= 0 {
 		return errors.New("database data file size must be greater than 0")
 	}
+	if options.DataFileSize > math.MaxUint32 {
+		return errors.New("database data file size must not exceed 4GB")
+	}
 	if options.DataFileMerGeRatio < 0 || options.DataFileMerGeRatio > 1 {
 		return errors.New("database data file merge ratio must be in [0, 1]")
 	}
+	if options.MaxOpenFiles < 0 {
+		return errors.New("database max open files must not be negative")
+	}
+	if options.BackgroundIORate < 0 {
+		return errors.New("database background io rate must not be negative")
+	}
+	if options.CacheSize < 0 {
+		return errors.New("database cache size must not be negative")
+	}
+	if options.BloomFilterFPRate < 0 || options.BloomFilterFPRate >= 1 {
+		return errors.New("database bloom filter false positive rate must be in [0, 1)")
+	}
+	if options.Comparator != nil && !index.SupportsComparator(options.IndexType) {
+		return ErrComparatorUnsupported
+	}
 	return nil
 }
 
@@ -623,7 +1029,9 @@ func (db *DB) loadSeqNo() error {
 	if err != nil {
 		return err
 	}
-	db.seqNo = seqNo
+	if seqNo > db.seqNo {
+		db.seqNo = seqNo
+	}
 	db.seqNoFileExists = true
 	return os.Remove(filename)
 }
@@ -633,13 +1041,34 @@ func (db *DB) resetIOType() error {
 	if db.activeFile == nil {
 		return nil
 	}
-	if err := db.activeFile.SetIOManager(db.options.DirPath, fio.StandardFile); err != nil {
+	ioType := db.dataFileIOType()
+	if err := db.activeFile.SetIOManager(db.options.DirPath, ioType); err != nil {
 		return err
 	}
+	if db.fileCache != nil {
+		return db.fileCache.SetIOType(ioType)
+	}
 	for _, file := range db.olderFiles {
-		if err := file.SetIOManager(db.options.DirPath, fio.StandardFile); err != nil {
+		if err := file.SetIOManager(db.options.DirPath, ioType); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// 数据文件读写使用的 IO 类型，配置了 DirectIOAllReads 时使用 Direct I/O
+func (db *DB) dataFileIOType() fio.FileIOType {
+	if db.options.DirectIO&DirectIOAllReads != 0 {
+		return fio.DirectIO
+	}
+	return fio.StandardFile
+}
+
+// 返回读写受到后台任务限速的数据文件，与原数据文件共享底层的文件
+func (db *DB) rateLimitedDataFile(dataFile *data.DataFile) *data.DataFile {
+	return &data.DataFile{
+		FileId:    dataFile.FileId,
+		WriteOff:  dataFile.WriteOff,
+		IoManager: fio.NewRateLimitedIOManager(dataFile.IoManager, db.rateLimiter),
+	}
+}
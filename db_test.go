@@ -1,9 +1,16 @@
 package bitcaskgo
 
 import (
+	"bitcask-go/data"
+	"bitcask-go/index"
 	"bitcask-go/utils"
+	"bytes"
 	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -20,6 +27,14 @@ func destroyDB(db *DB) {
 		}
 	}
 }
+
+// mustStat 获取统计信息，失败时标记测试失败
+func mustStat(t *testing.T, db *DB) *Stat {
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	return stat
+}
+
 func TestOpen(t *testing.T) {
 	opts := DefaultOptions
 	db, err := Open(opts)
@@ -29,6 +44,51 @@ func TestOpen(t *testing.T) {
 
 }
 
+func TestOpen_UnsupportedIndexType(t *testing.T) {
+	opts := DefaultOptions
+	opts.IndexType = 100
+	defer os.RemoveAll(opts.DirPath)
+	db, err := Open(opts)
+	assert.Nil(t, db)
+	assert.Equal(t, index.ErrUnsupportedIndexType, err)
+
+	// 打开失败后释放目录锁，可以重新打开
+	db, err = Open(DefaultOptions)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+}
+
+func TestOpen_ReleaseOnFailure(t *testing.T) {
+	for _, typ := range []IndexerType{BTree, BPTree} {
+		opts := DefaultOptions
+		opts.IndexType = typ
+		opts.DataFileSize = 32 * 1024
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		for i := 0; i < 1000; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+		}
+		assert.Nil(t, db.Close())
+
+		// 索引已经打开之后加载数据文件失败
+		badFile := filepath.Join(opts.DirPath, "abc"+data.DataFileNameSuffix)
+		assert.Nil(t, os.WriteFile(badFile, nil, 0644))
+		db, err = Open(opts)
+		assert.Nil(t, db)
+		assert.Equal(t, ErrDataDirectoryCorrupted, err)
+
+		// 打开失败后释放了目录锁和索引文件，可以重新打开
+		assert.Nil(t, os.Remove(badFile))
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		for i := 0; i < 1000; i++ {
+			_, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+		}
+		destroyDB(db)
+	}
+}
+
 func TestDB_Put(t *testing.T) {
 	opts := DefaultOptions
 	opts.DataFileSize = 128 * 1024
@@ -297,7 +357,8 @@ func TestDB_Stat(t *testing.T) {
 		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
 		assert.Nil(t, err)
 	}
-	stat := db.Stat()
+	stat, err := db.Stat()
+	assert.Nil(t, err)
 	assert.NotNil(t, stat)
 }
 
@@ -325,3 +386,493 @@ func TestDB_Backup(t *testing.T) {
 	assert.NotNil(t, db1)
 
 }
+
+func TestDB_PreallocateDataFile(t *testing.T) {
+	opts := DefaultOptions
+	opts.DataFileSize = 64 * 1024
+	opts.PreallocateDataFile = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.olderFiles) > 0)
+
+	// 轮转后的旧文件大小等于实际写入的大小
+	for _, file := range db.olderFiles {
+		size, err := file.IoManager.Size()
+		assert.Nil(t, err)
+		assert.Equal(t, file.WriteOff, size)
+	}
+
+	// 重启后数据完整，并且可以继续写入
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1000), utils.RandomValue(128))
+	assert.Nil(t, err)
+	for i := 0; i <= 1000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+}
+
+func TestDB_DirectIO(t *testing.T) {
+	opts := DefaultOptions
+	opts.DataFileSize = 64 * 1024
+	opts.DirectIO = DirectIOAllReads | DirectIOBackup
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	values := make([][]byte, 1000)
+	for i := 0; i < 1000; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+
+	backupDir := "./tmp-backup"
+	err = db.Backup(backupDir)
+	assert.Nil(t, err)
+
+	opts1 := DefaultOptions
+	opts1.DirPath = backupDir
+	opts1.DataFileSize = 64 * 1024
+	db1, err := Open(opts1)
+	defer destroyDB(db1)
+	assert.Nil(t, err)
+	assert.NotNil(t, db1)
+	for i := 0; i < 1000; i++ {
+		val, err := db1.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+}
+
+func TestDB_MaxOpenFiles(t *testing.T) {
+	opts := DefaultOptions
+	opts.DataFileSize = 32 * 1024
+	opts.MaxOpenFiles = 2
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	values := make([][]byte, 1000)
+	for i := 0; i < 1000; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.olderFiles) > opts.MaxOpenFiles)
+	for i := 0; i < 1000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.True(t, stat.FileCacheHits > 0)
+	assert.True(t, stat.FileCacheMisses > 0)
+
+	// 重启后旧的数据文件按需打开
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	for i := 0; i < 1000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+	err = db.Put(utils.GetTestKey(1000), utils.RandomValue(128))
+	assert.Nil(t, err)
+}
+
+func TestDB_ValueCache(t *testing.T) {
+	opts := DefaultOptions
+	opts.CacheSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	key, value := utils.GetTestKey(1), utils.RandomValue(128)
+	err = db.Put(key, value)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		val, err := db.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(9), stat.CacheHits)
+	assert.Equal(t, uint64(1), stat.CacheMisses)
+	assert.True(t, stat.CacheSize > 0)
+
+	// 更新后读取到新的数据
+	value2 := utils.RandomValue(128)
+	err = db.Put(key, value2)
+	assert.Nil(t, err)
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, value2, val)
+
+	// 删除后读取不到数据
+	err = db.Delete(key)
+	assert.Nil(t, err)
+	_, err = db.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+// 每种索引类型都需要支持写入、删除、有序遍历以及重启之后重建索引，
+// check 中是各个索引类型特有的校验，在重启之后的数据库上执行
+func TestDB_IndexTypes(t *testing.T) {
+	tests := []struct {
+		name      string
+		indexType IndexerType
+		check     func(t *testing.T, db *DB)
+	}{
+		{name: "btree", indexType: BTree},
+		{name: "art", indexType: ART},
+		{name: "bptree", indexType: BPTree},
+		{name: "hash", indexType: Hash, check: checkHashIndex},
+		{name: "sharded-btree", indexType: ShardedBTree, check: checkShardedBTreeIndex},
+		{name: "compact", indexType: Compact, check: checkCompactIndex},
+		{name: "skiplist", indexType: SkipList, check: checkSkipListIndex},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions
+			opts.IndexType = tt.indexType
+			db, err := Open(opts)
+			assert.Nil(t, err)
+			assert.NotNil(t, db)
+
+			for i := 0; i < 100; i++ {
+				err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+				assert.Nil(t, err)
+			}
+			err = db.Delete(utils.GetTestKey(0))
+			assert.Nil(t, err)
+
+			// 迭代器按照 key 的顺序遍历
+			iter := db.NewIterator(DefaultIteratorOptions)
+			var i = 1
+			for iter.Rewind(); iter.Valid(); iter.Next() {
+				assert.Equal(t, utils.GetTestKey(i), iter.Key())
+				i++
+			}
+			iter.Close()
+			assert.Equal(t, 100, i)
+
+			// 重启后重建索引
+			err = db.Close()
+			assert.Nil(t, err)
+			db, err = Open(opts)
+			defer destroyDB(db)
+			assert.Nil(t, err)
+			_, err = db.Get(utils.GetTestKey(0))
+			assert.Equal(t, ErrKeyNotFound, err)
+			for i := 1; i < 100; i++ {
+				val, err := db.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.Equal(t, utils.GetTestKey(i), val)
+			}
+
+			if tt.check != nil {
+				tt.check(t, db)
+			}
+		})
+	}
+}
+
+// checkHashIndex key 的数量远多于哈希索引的分片数量，同一个分片中的 key 互不影响
+func checkHashIndex(t *testing.T, db *DB) {
+	keys := [][]byte{[]byte("a"), []byte("a\x00"), []byte("\x00a"), []byte("ab"), []byte("ba")}
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, utils.GetTestKey(i+1000))
+	}
+	for i, key := range keys {
+		assert.Nil(t, db.Put(key, []byte(strconv.Itoa(i))))
+	}
+	assert.Nil(t, db.Delete([]byte("a")))
+
+	_, err := db.Get([]byte("a"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	for i, key := range keys[1:] {
+		val, err := db.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(strconv.Itoa(i+1)), val)
+	}
+	assert.Equal(t, uint(99+len(keys)-1), mustStat(t, db).KeyNum)
+}
+
+// checkShardedBTreeIndex 多个写入者并发写入之后，合并各个分片的迭代器仍然按照全局顺序遍历
+func checkShardedBTreeIndex(t *testing.T, db *DB) {
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 100 + w; i < 1000; i += 4 {
+				assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	iter := db.NewIterator(DefaultIteratorOptions)
+	var i = 1
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, utils.GetTestKey(i), iter.Key())
+		i++
+	}
+	assert.Equal(t, 1000, i)
+	iter.Seek(utils.GetTestKey(500))
+	assert.Equal(t, utils.GetTestKey(500), iter.Key())
+	iter.Close()
+
+	iterOpts := DefaultIteratorOptions
+	iterOpts.Reverse = true
+	iter = db.NewIterator(iterOpts)
+	i = 999
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, utils.GetTestKey(i), iter.Key())
+		i--
+	}
+	assert.Equal(t, 0, i)
+	iter.Seek(utils.GetTestKey(500))
+	assert.Equal(t, utils.GetTestKey(500), iter.Key())
+	iter.Next()
+	assert.Equal(t, utils.GetTestKey(499), iter.Key())
+	iter.Close()
+}
+
+// checkCompactIndex 超过存储区块大小的 key 和打包保存的位置信息都能完整地读出
+func checkCompactIndex(t *testing.T, db *DB) {
+	stat := mustStat(t, db)
+	assert.Equal(t, uint(99), stat.KeyNum)
+	assert.True(t, stat.IndexMemSize > 0)
+
+	bigKey := bytes.Repeat([]byte("k"), 1<<20+1)
+	bigValue := utils.RandomValue(1 << 16)
+	assert.Nil(t, db.Put(bigKey, bigValue))
+	assert.Nil(t, db.Put([]byte("empty"), nil))
+	val, err := db.Get(bigKey)
+	assert.Nil(t, err)
+	assert.Equal(t, bigValue, val)
+	size, err := db.ValueSize(bigKey)
+	assert.Nil(t, err)
+	assert.Equal(t, len(bigValue), size)
+	size, err = db.ValueSize([]byte("empty"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, size)
+	modTime, err := db.ModTime(bigKey)
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now(), modTime, time.Minute)
+	assert.True(t, mustStat(t, db).IndexMemSize > 1<<20)
+
+	assert.Nil(t, db.Delete(bigKey))
+	_, err = db.Get(bigKey)
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), val)
+}
+
+// checkSkipListIndex 迭代器创建之后的写入和删除对迭代器不可见
+func checkSkipListIndex(t *testing.T, db *DB) {
+	iter := db.NewIterator(DefaultIteratorOptions)
+	var i = 1
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, utils.GetTestKey(i), iter.Key())
+		val, err := iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+		assert.Nil(t, db.Put(utils.GetTestKey(i+1000), utils.RandomValue(10)))
+		if i+1 < 100 {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i+1)))
+		}
+		i++
+	}
+	iter.Close()
+	assert.Equal(t, 100, i)
+	assert.Equal(t, uint(100), mustStat(t, db).KeyNum)
+}
+
+func TestDB_BPTreeBloomFilter(t *testing.T) {
+	opts := DefaultOptions
+	opts.IndexType = BPTree
+	opts.BloomFilterFPRate = 0.01
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(100), utils.GetTestKey(100)))
+	assert.Nil(t, wb.Commit())
+	assert.Greater(t, mustStat(t, db).BloomFilterSize, int64(0))
+
+	for i := 0; i <= 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	_, err = db.Get(utils.GetTestKey(1000))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 重启后根据索引重建布隆过滤器
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i <= 100; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	_, err = db.Get(utils.GetTestKey(1000))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_BPTreeIndexFailed(t *testing.T) {
+	opts := DefaultOptions
+	opts.IndexType = BPTree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(0), utils.GetTestKey(0)))
+
+	// 关闭 bbolt 模拟索引读写失败，错误返回给调用方而不是 panic
+	assert.Nil(t, db.index.Close())
+	err = db.Put(utils.GetTestKey(1), utils.GetTestKey(1))
+	assert.ErrorIs(t, err, ErrIndexFailed)
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.ErrorIs(t, err, ErrIndexFailed)
+	assert.ErrorIs(t, db.Delete(utils.GetTestKey(0)), ErrIndexFailed)
+	_, err = db.Stat()
+	assert.ErrorIs(t, err, ErrIndexFailed)
+	// 不会记录缺少修改的检查点
+	assert.ErrorIs(t, db.Sync(), ErrIndexFailed)
+	assert.NotNil(t, db.Close())
+
+	// 重新打开后从数据文件中恢复索引
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+}
+
+func TestDB_ValueSizeAndModTime(t *testing.T) {
+	opts := DefaultOptions
+	opts.DataFileMerGeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	start := time.Now()
+	value1 := utils.RandomValue(100)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), value1))
+	assert.Nil(t, db.Put(utils.GetTestKey(2), []byte{}))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	value3 := utils.RandomValue(20)
+	assert.Nil(t, wb.Put(utils.GetTestKey(3), value3))
+	assert.Nil(t, wb.Commit())
+
+	size, err := db.ValueSize(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, len(value1), size)
+	size, err = db.ValueSize(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, size)
+	_, err = db.ValueSize(utils.GetTestKey(4))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	modTime, err := db.ModTime(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.False(t, modTime.Before(start))
+	assert.False(t, modTime.After(time.Now()))
+
+	// merge 之后从 hint 文件加载索引，写入时间保持不变
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	size, err = db.ValueSize(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, len(value3), size)
+	modTime2, err := db.ModTime(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.True(t, modTime.Equal(modTime2))
+
+	iter := db.NewIterator(DefaultIteratorOptions)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		value, err := iter.Value()
+		assert.Nil(t, err)
+		size, err := iter.ValueSize()
+		assert.Nil(t, err)
+		assert.Equal(t, len(value), size)
+		assert.False(t, iter.ModTime().IsZero())
+	}
+}
+
+func TestDB_MultiGet(t *testing.T) {
+	opts := DefaultOptions
+	opts.DataFileSize = 32 * 1024
+	opts.CacheSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(10)))
+	// 先读取一部分数据，使其进入缓存
+	_, err = db.Get(utils.GetTestKey(20))
+	assert.Nil(t, err)
+
+	keys := [][]byte{utils.GetTestKey(499), utils.GetTestKey(10), nil, utils.GetTestKey(20), utils.GetTestKey(1000)}
+	for i := 0; i < 400; i += 7 {
+		keys = append(keys, utils.GetTestKey(i+1))
+	}
+	values, errs := db.MultiGet(keys)
+	assert.Equal(t, len(keys), len(values))
+	assert.Equal(t, len(keys), len(errs))
+	assert.Equal(t, ErrKeyNotFound, errs[1])
+	assert.Equal(t, ErrKeyIsEmpty, errs[2])
+	assert.Equal(t, ErrKeyNotFound, errs[4])
+	for i, key := range keys {
+		if i == 1 || i == 2 || i == 4 {
+			assert.Nil(t, values[i])
+			continue
+		}
+		assert.Nil(t, errs[i])
+		assert.Equal(t, key, values[i])
+	}
+}
@@ -15,4 +15,8 @@ var (
 	ErrDatabaseIsUsing        = errors.New("the database directory is used by another process")
 	ErrMergeRatioUnreached    = errors.New("the merge ratio do not reach the option")
 	ErrNoEnoughSpaceForMerge  = errors.New("no enough disk space for merge")
+	ErrComparatorUnsupported  = errors.New("the index type does not support custom comparator")
+	ErrInvalidScanCursor      = errors.New("the scan cursor is invalid")
+	ErrInvalidScanLimit       = errors.New("the scan limit must be greater than 0")
+	ErrIndexFailed            = errors.New("the index failed, reopen the database to recover")
 )
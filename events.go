@@ -0,0 +1,193 @@
+package bitcaskgo
+
+import (
+	"bitcask-go/data"
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// EventListener 接收存储引擎内部事件的回调，用于接入监控和告警
+// 回调在引擎的内部流程中同步执行，部分回调执行时持有数据库的锁，不能阻塞，也不能调用数据库的方法。
+// 只关心部分事件时可以嵌入 NoopEventListener
+type EventListener interface {
+	// OnFileRotated 活跃文件写满，切换到新的活跃文件
+	OnFileRotated(info FileRotatedInfo)
+
+	// OnMergeBegin 开始 merge
+	OnMergeBegin(info MergeInfo)
+
+	// OnMergeEnd merge 结束，失败时 Err 不为空
+	OnMergeEnd(info MergeInfo)
+
+	// OnSync 活跃文件持久化完成
+	OnSync(info SyncInfo)
+
+	// OnRecovery 打开数据库时执行了恢复操作
+	OnRecovery(info RecoveryInfo)
+
+	// OnCorruption 读取数据文件时发现数据损坏
+	OnCorruption(info CorruptionInfo)
+}
+
+// NoopEventListener 忽略所有事件的 EventListener
+type NoopEventListener struct{}
+
+func (NoopEventListener) OnFileRotated(FileRotatedInfo) {}
+func (NoopEventListener) OnMergeBegin(MergeInfo)        {}
+func (NoopEventListener) OnMergeEnd(MergeInfo)          {}
+func (NoopEventListener) OnSync(SyncInfo)               {}
+func (NoopEventListener) OnRecovery(RecoveryInfo)       {}
+func (NoopEventListener) OnCorruption(CorruptionInfo)   {}
+
+// FileRotatedInfo 切换活跃文件的信息
+type FileRotatedInfo struct {
+	OldFileId   uint32 // 写满的活跃文件 id
+	OldFileSize int64  // 写满的活跃文件的数据大小
+	NewFileId   uint32 // 新的活跃文件 id
+}
+
+// MergeInfo merge 的信息
+type MergeInfo struct {
+	FileNum         int           // 参与 merge 的数据文件数量
+	ReclaimableSize int64         // 开始 merge 时可回收的数据大小
+	Duration        time.Duration // merge 耗时，OnMergeBegin 时为 0
+	ReclaimedSize   int64         // merge 之后减少的数据大小，OnMergeBegin 时为 0
+	Err             error         // merge 失败的原因
+}
+
+// SyncInfo 持久化的信息
+type SyncInfo struct {
+	FileId   uint32        // 持久化的活跃文件 id
+	Duration time.Duration // 持久化耗时
+	Err      error         // 持久化失败的原因
+}
+
+// RecoveryAction 打开数据库时的恢复操作
+type RecoveryAction int
+
+const (
+	// RecoveryMergeApplied 应用了上一次完成的 merge 结果
+	RecoveryMergeApplied RecoveryAction = iota + 1
+
+	// RecoveryMergeDiscarded 丢弃了上一次未完成的 merge 结果
+	RecoveryMergeDiscarded
+
+	// RecoveryCheckpointReplay B+ 树索引从检查点开始重放数据文件
+	RecoveryCheckpointReplay
+
+	// RecoveryTxnDiscarded 丢弃了没有完成标识的事务数据
+	RecoveryTxnDiscarded
+
+	// RecoveryTailTruncated 截断了活跃文件末尾未写完的数据或预分配的空间
+	RecoveryTailTruncated
+)
+
+func (a RecoveryAction) String() string {
+	switch a {
+	case RecoveryMergeApplied:
+		return "merge-applied"
+	case RecoveryMergeDiscarded:
+		return "merge-discarded"
+	case RecoveryCheckpointReplay:
+		return "checkpoint-replay"
+	case RecoveryTxnDiscarded:
+		return "txn-discarded"
+	case RecoveryTailTruncated:
+		return "tail-truncated"
+	default:
+		return "unknown"
+	}
+}
+
+// RecoveryInfo 恢复操作的信息，不同的操作使用不同的字段
+type RecoveryInfo struct {
+	Action RecoveryAction
+	FileId uint32 // 相关的数据文件 id
+	Offset int64  // 开始重放或者截断的位置
+	Count  int    // 丢弃的事务数量或者截断的字节数
+}
+
+// CorruptionInfo 数据损坏的信息
+type CorruptionInfo struct {
+	FileId uint32
+	Offset int64
+	Err    error
+}
+
+// discardLogger 没有配置 Logger 时使用，丢弃所有日志
+var discardLogger = slog.New(discardHandler{})
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// initObservability 根据配置项初始化日志、事件回调和运行时指标
+func (db *DB) initObservability() {
+	db.logger = db.options.Logger
+	if db.logger == nil {
+		db.logger = discardLogger
+	}
+	db.logger = db.logger.With("dir", db.options.DirPath)
+	db.listener = db.options.EventListener
+	if db.listener == nil {
+		db.listener = NoopEventListener{}
+	}
+	db.metrics = newEngineMetrics()
+}
+
+func (db *DB) onFileRotated(info FileRotatedInfo) {
+	db.metrics.rotations.Inc()
+	db.logger.Info("data file rotated",
+		"old_fid", info.OldFileId, "old_size", info.OldFileSize, "new_fid", info.NewFileId)
+	db.listener.OnFileRotated(info)
+}
+
+func (db *DB) onMergeBegin(info MergeInfo) {
+	db.logger.Info("merge started", "files", info.FileNum, "reclaimable", info.ReclaimableSize)
+	db.listener.OnMergeBegin(info)
+}
+
+func (db *DB) onMergeEnd(info MergeInfo) {
+	if info.Err != nil {
+		db.logger.Error("merge failed", "files", info.FileNum, "duration", info.Duration, "err", info.Err)
+	} else {
+		db.metrics.merges.Inc()
+		db.metrics.reclaimed.Add(uint64(info.ReclaimedSize))
+		db.metrics.mergeDuration.Observe(info.Duration)
+		db.logger.Info("merge finished", "files", info.FileNum, "duration", info.Duration,
+			"reclaimed", info.ReclaimedSize)
+	}
+	db.listener.OnMergeEnd(info)
+}
+
+func (db *DB) onSync(info SyncInfo) {
+	if info.Err != nil {
+		db.logger.Error("sync failed", "fid", info.FileId, "err", info.Err)
+	} else {
+		db.metrics.syncs.Inc()
+		db.metrics.syncLatency.Observe(info.Duration)
+		db.logger.Debug("synced", "fid", info.FileId, "duration", info.Duration)
+	}
+	db.listener.OnSync(info)
+}
+
+func (db *DB) onRecovery(info RecoveryInfo) {
+	db.logger.Warn("recovery", "action", info.Action.String(),
+		"fid", info.FileId, "offset", info.Offset, "count", info.Count)
+	db.listener.OnRecovery(info)
+}
+
+// reportCorruption err 表示数据损坏时通知监听者
+func (db *DB) reportCorruption(fid uint32, offset int64, err error) {
+	if !errors.Is(err, data.ErrInvalidCRC) {
+		return
+	}
+	db.metrics.corruptions.Inc()
+	db.logger.Error("data file corrupted", "fid", fid, "offset", offset, "err", err)
+	db.listener.OnCorruption(CorruptionInfo{FileId: fid, Offset: offset, Err: err})
+}
@@ -0,0 +1,145 @@
+package bitcaskgo
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingListener struct {
+	NoopEventListener
+	mu          sync.Mutex
+	rotations   []FileRotatedInfo
+	syncs       int
+	mergeBegins []MergeInfo
+	mergeEnds   []MergeInfo
+	recoveries  []RecoveryInfo
+	corruptions []CorruptionInfo
+}
+
+func (l *recordingListener) OnFileRotated(info FileRotatedInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rotations = append(l.rotations, info)
+}
+
+func (l *recordingListener) OnSync(info SyncInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.syncs++
+}
+
+func (l *recordingListener) OnMergeBegin(info MergeInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mergeBegins = append(l.mergeBegins, info)
+}
+
+func (l *recordingListener) OnMergeEnd(info MergeInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mergeEnds = append(l.mergeEnds, info)
+}
+
+func (l *recordingListener) OnRecovery(info RecoveryInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recoveries = append(l.recoveries, info)
+}
+
+func (l *recordingListener) OnCorruption(info CorruptionInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.corruptions = append(l.corruptions, info)
+}
+
+func TestDB_EventListener(t *testing.T) {
+	var logs bytes.Buffer
+	listener := &recordingListener{}
+	opts := DefaultOptions
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMerGeRatio = 0
+	opts.EventListener = listener
+	opts.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	assert.NotEmpty(t, listener.rotations)
+	assert.Equal(t, uint32(0), listener.rotations[0].OldFileId)
+	assert.Equal(t, uint32(1), listener.rotations[0].NewFileId)
+	assert.Greater(t, listener.syncs, 0)
+
+	rotations := len(listener.rotations)
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(listener.mergeBegins))
+	assert.Equal(t, 1, len(listener.mergeEnds))
+	assert.Equal(t, rotations+1, len(listener.rotations))
+	assert.Equal(t, listener.mergeBegins[0].FileNum, listener.mergeEnds[0].FileNum)
+	assert.Nil(t, listener.mergeEnds[0].Err)
+
+	// 重启时应用 merge 的结果
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, RecoveryMergeApplied, listener.recoveries[0].Action)
+	assert.Contains(t, logs.String(), "merge finished")
+}
+
+func TestDB_EventListenerRecovery(t *testing.T) {
+	listener := &recordingListener{}
+	opts := DefaultOptions
+	opts.EventListener = listener
+	db, err := Open(opts)
+	defer os.RemoveAll(opts.DirPath)
+	assert.Nil(t, err)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(10))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 模拟写了一半的数据
+	fileName := data.GetDataFileName(opts.DirPath, 0)
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{1, 2, 3})
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(listener.recoveries))
+	assert.Equal(t, RecoveryTailTruncated, listener.recoveries[0].Action)
+	assert.Equal(t, 3, listener.recoveries[0].Count)
+
+	// 损坏第二条数据的 value
+	pos := db.index.Get(utils.GetTestKey(2))
+	err = db.Close()
+	assert.Nil(t, err)
+	f, err = os.OpenFile(fileName, os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte{0xff}, pos.Offset+int64(pos.Size)-1)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	opts.MMapAtStartup = false
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+	assert.Equal(t, 1, len(listener.corruptions))
+	assert.Equal(t, pos.Offset, listener.corruptions[0].Offset)
+}
@@ -0,0 +1,191 @@
+package bitcaskgo
+
+import (
+	"bitcask-go/data"
+	"encoding/binary"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// 数据文件的统计信息
+// 写入、覆盖和删除数据时增量更新，删除标记和事务完成标识写入后即为无效数据。
+// B+ 树索引启动时只重放检查点之后的数据，统计信息和检查点一起原子地保存在索引文件中；
+// 其他索引启动时会重新加载 hint 文件和数据文件，统计信息也随之重新计算
+
+// fileStatsVersion 持久化的统计信息的编码版本
+const fileStatsVersion byte = 1
+
+// FileStat 单个数据文件的统计信息
+type FileStat struct {
+	FileId     uint32
+	Size       int64 // 文件中数据的大小
+	LiveSize   int64 // 仍然有效的数据大小
+	DeadSize   int64 // 被覆盖或删除，可以被 merge 回收的数据大小
+	Records    int64 // 数据的条数，包括删除标记和事务完成标识
+	Tombstones int64 // 删除标记的条数
+}
+
+// fileStats 所有数据文件的统计信息，可以并发更新
+type fileStats struct {
+	mu    sync.Mutex
+	files map[uint32]*FileStat
+}
+
+func newFileStats() *fileStats {
+	return &fileStats{files: make(map[uint32]*FileStat)}
+}
+
+// get 需要持有 fs.mu
+func (fs *fileStats) get(fid uint32) *FileStat {
+	stat, ok := fs.files[fid]
+	if !ok {
+		stat = &FileStat{FileId: fid}
+		fs.files[fid] = stat
+	}
+	return stat
+}
+
+func (fs *fileStats) addRecord(pos *data.LogRecordPos, typ data.LogRecordType) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	stat := fs.get(pos.Fid)
+	stat.Size += int64(pos.Size)
+	stat.Records++
+	if typ == data.LogRecordDeleted {
+		stat.Tombstones++
+	}
+}
+
+func (fs *fileStats) addDead(pos *data.LogRecordPos) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.get(pos.Fid).DeadSize += int64(pos.Size)
+}
+
+// remove 移除数据文件的统计信息，返回文件中无效数据的大小
+func (fs *fileStats) remove(fid uint32) int64 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	stat, ok := fs.files[fid]
+	if !ok {
+		return 0
+	}
+	delete(fs.files, fid)
+	return stat.DeadSize
+}
+
+// snapshot 按照文件 id 排序返回所有数据文件的统计信息
+func (fs *fileStats) snapshot() []FileStat {
+	fs.mu.Lock()
+	stats := make([]FileStat, 0, len(fs.files))
+	for _, stat := range fs.files {
+		s := *stat
+		s.LiveSize = s.Size - s.DeadSize
+		stats = append(stats, s)
+	}
+	fs.mu.Unlock()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].FileId < stats[j].FileId })
+	return stats
+}
+
+// encode 编码所有数据文件的统计信息
+func (fs *fileStats) encode() []byte {
+	stats := fs.snapshot()
+	buf := make([]byte, 1+binary.MaxVarintLen64*(1+5*len(stats)))
+	buf[0] = fileStatsVersion
+	index := 1
+	index += binary.PutUvarint(buf[index:], uint64(len(stats)))
+	for _, stat := range stats {
+		index += binary.PutUvarint(buf[index:], uint64(stat.FileId))
+		index += binary.PutVarint(buf[index:], stat.Size)
+		index += binary.PutVarint(buf[index:], stat.DeadSize)
+		index += binary.PutVarint(buf[index:], stat.Records)
+		index += binary.PutVarint(buf[index:], stat.Tombstones)
+	}
+	return buf[:index]
+}
+
+// decodeFileStats 解码统计信息，数据无法识别时返回 false
+func decodeFileStats(buf []byte) (map[uint32]*FileStat, bool) {
+	if len(buf) == 0 || buf[0] != fileStatsVersion {
+		return nil, false
+	}
+	index := 1
+	uvarint := func() uint64 {
+		if index < 0 {
+			return 0
+		}
+		v, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			index = -1
+			return 0
+		}
+		index += n
+		return v
+	}
+	varint := func() int64 {
+		if index < 0 {
+			return 0
+		}
+		v, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			index = -1
+			return 0
+		}
+		index += n
+		return v
+	}
+
+	count := uvarint()
+	files := make(map[uint32]*FileStat)
+	for i := uint64(0); i < count && index >= 0; i++ {
+		stat := &FileStat{FileId: uint32(uvarint())}
+		stat.Size = varint()
+		stat.DeadSize = varint()
+		stat.Records = varint()
+		stat.Tombstones = varint()
+		files[stat.FileId] = stat
+	}
+	if index < 0 {
+		return nil, false
+	}
+	return files, true
+}
+
+// FileStats 返回每个数据文件的统计信息，按照文件 id 排序
+// merge 之后被替换的数据文件在重新打开数据库之后才会更新
+func (db *DB) FileStats() []FileStat {
+	return db.fileStats.snapshot()
+}
+
+// accountRecord 统计新写入的数据，删除标记和事务完成标识写入之后就是无效数据
+func (db *DB) accountRecord(pos *data.LogRecordPos, typ data.LogRecordType) {
+	db.fileStats.addRecord(pos, typ)
+	if typ == data.LogRecordDeleted || typ == data.LogRecordTxnFinished {
+		db.markStale(pos)
+	}
+}
+
+// markStale 数据被覆盖、删除或者丢弃之后成为可以回收的无效数据
+func (db *DB) markStale(pos *data.LogRecordPos) {
+	atomic.AddInt64(&db.reclaimSize, int64(pos.Size))
+	db.fileStats.addDead(pos)
+}
+
+// loadFileStats 从 B+ 树索引的检查点中加载统计信息，之后只需要重放检查点之后的数据
+func (db *DB) loadFileStats(meta []byte) {
+	files, ok := decodeFileStats(meta)
+	if !ok {
+		return
+	}
+	db.fileStats.files = files
+	for _, stat := range files {
+		db.reclaimSize += stat.DeadSize
+	}
+}
+
+// removeFileStats merge 之后旧的数据文件被删除时移除对应的统计信息
+func (db *DB) removeFileStats(fid uint32) {
+	atomic.AddInt64(&db.reclaimSize, -db.fileStats.remove(fid))
+}
@@ -0,0 +1,125 @@
+package bitcaskgo
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// checkFileStats 校验统计信息和数据文件、可回收数据大小一致
+func checkFileStats(t *testing.T, db *DB) []FileStat {
+	stats := db.FileStats()
+	var deadSize int64
+	for _, stat := range stats {
+		var dataFile = db.olderFiles[stat.FileId]
+		if db.activeFile.FileId == stat.FileId {
+			dataFile = db.activeFile
+		}
+		assert.NotNil(t, dataFile)
+		size, err := dataFile.IoManager.Size()
+		assert.Nil(t, err)
+		assert.Equal(t, size, stat.Size)
+		assert.Equal(t, stat.Size, stat.LiveSize+stat.DeadSize)
+		deadSize += stat.DeadSize
+	}
+	assert.Equal(t, mustStat(t, db).ReclaimableSize, deadSize)
+	return stats
+}
+
+func writeFileStatsData(t *testing.T, db *DB) {
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	// 覆盖和删除一部分数据
+	for i := 0; i < 500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	for i := 500; i < 800; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(900), utils.RandomValue(64)))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(901)))
+	assert.Nil(t, wb.Commit())
+}
+
+func TestDB_FileStats(t *testing.T) {
+	opts := DefaultOptions
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMerGeRatio = 0
+	opts.MMapAtStartup = false
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	writeFileStatsData(t, db)
+	stats := checkFileStats(t, db)
+	assert.Greater(t, len(stats), 1)
+	var records, tombstones int64
+	for _, stat := range stats {
+		records += stat.Records
+		tombstones += stat.Tombstones
+	}
+	// 普通写入、删除、事务中的两条数据和完成标识
+	assert.Equal(t, int64(2500+300+3), records)
+	assert.Equal(t, int64(301), tombstones)
+
+	// 重启之后统计信息不变
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, stats, checkFileStats(t, db))
+
+	// merge 之后重启，旧的数据文件替换为只包含有效数据的文件
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(64))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	merged := checkFileStats(t, db2)
+	for _, stat := range merged {
+		assert.Equal(t, int64(0), stat.Tombstones)
+	}
+	assert.Equal(t, 2000-301, db2.index.Size())
+	// 只有 merge 之后被覆盖的一条数据是无效的
+	assert.Equal(t, int64(db2.index.Get(utils.GetTestKey(1)).Size), mustStat(t, db2).ReclaimableSize)
+}
+
+func TestDB_FileStatsBPTree(t *testing.T) {
+	opts := DefaultOptions
+	opts.DataFileSize = 64 * 1024
+	opts.IndexType = BPTree
+	opts.MMapAtStartup = false
+	db, err := Open(opts)
+	defer os.RemoveAll(opts.DirPath)
+	assert.Nil(t, err)
+
+	writeFileStatsData(t, db)
+	stats := checkFileStats(t, db)
+
+	// B+ 树索引只重放检查点之后的数据，统计信息和检查点一起保存
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, stats, checkFileStats(t, db))
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(64))
+	assert.Nil(t, err)
+	stats = checkFileStats(t, db)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, stats, checkFileStats(t, db))
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,131 @@
+//go:build linux
+
+package fio
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// directIOAlignment Direct I/O 要求的偏移、长度和内存地址对齐大小
+const directIOAlignment = 4096
+
+// 单个对齐块的读缓冲池，大部分读取（如记录头部）都不会超过一个块
+var alignedBlockPool = sync.Pool{
+	New: func() any {
+		return alignedBlock(directIOAlignment)
+	},
+}
+
+// DirectFileIO 使用 O_DIRECT 绕过页缓存读取数据的文件 IO
+// 写入仍然通过标准文件 IO 追加，并在持久化之后将对应的页缓存丢弃
+type DirectFileIO struct {
+	*FileIO
+	directFd *os.File // 以 O_DIRECT 打开的只读文件描述符
+}
+
+// NewDirectIOManager 初始化 DirectFileIO
+// 文件系统不支持 O_DIRECT 时退化为标准文件 IO
+func NewDirectIOManager(fileName string) (IOManager, error) {
+	fileIO, err := NewFileIOManager(fileName)
+	if err != nil {
+		return nil, err
+	}
+	directFd, err := os.OpenFile(fileName, os.O_RDONLY|unix.O_DIRECT, DataFilePerm)
+	if err != nil {
+		if errors.Is(err, unix.EINVAL) {
+			return fileIO, nil
+		}
+		_ = fileIO.Close()
+		return nil, err
+	}
+	return &DirectFileIO{FileIO: fileIO, directFd: directFd}, nil
+}
+
+// Read 从文件给定位置读取对应的数据，按块对齐后直接从磁盘读取
+func (dio *DirectFileIO) Read(b []byte, offset int64) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	start := offset &^ (directIOAlignment - 1)
+	end := (offset + int64(len(b)) + directIOAlignment - 1) &^ (directIOAlignment - 1)
+
+	var buf []byte
+	if end-start == directIOAlignment {
+		buf = alignedBlockPool.Get().([]byte)
+		defer alignedBlockPool.Put(buf)
+	} else {
+		buf = alignedBlock(int(end - start))
+	}
+
+	n, err := dio.preadAligned(buf, start)
+	if err != nil {
+		// 底层设备不满足对齐要求时退化为标准读取
+		if errors.Is(err, unix.EINVAL) {
+			return dio.FileIO.Read(b, offset)
+		}
+		return 0, err
+	}
+
+	skip := int(offset - start)
+	if n <= skip {
+		return 0, io.EOF
+	}
+	copied := copy(b, buf[skip:n])
+	if copied < len(b) {
+		return copied, io.EOF
+	}
+	return copied, nil
+}
+
+// Sync 持久化数据，并丢弃写入时产生的页缓存
+func (dio *DirectFileIO) Sync() error {
+	if err := dio.FileIO.Sync(); err != nil {
+		return err
+	}
+	return unix.Fadvise(int(dio.fd.Fd()), 0, 0, unix.FADV_DONTNEED)
+}
+
+// Close 关闭文件
+func (dio *DirectFileIO) Close() error {
+	if err := dio.directFd.Close(); err != nil {
+		return err
+	}
+	return dio.FileIO.Close()
+}
+
+// preadAligned 从对齐的位置读取数据，读到文件末尾时返回实际读取的长度
+func (dio *DirectFileIO) preadAligned(buf []byte, offset int64) (int, error) {
+	fd := int(dio.directFd.Fd())
+	var read int
+	for read < len(buf) {
+		n, err := unix.Pread(fd, buf[read:], offset+int64(read))
+		if err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return 0, err
+		}
+		read += n
+		// 读取的长度不是块大小的整数倍，说明到达了文件末尾
+		if n == 0 || n%directIOAlignment != 0 {
+			break
+		}
+	}
+	return read, nil
+}
+
+// alignedBlock 分配起始地址按 directIOAlignment 对齐的缓冲区
+func alignedBlock(size int) []byte {
+	buf := make([]byte, size+directIOAlignment)
+	var shift int
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlignment - 1)); rem != 0 {
+		shift = directIOAlignment - rem
+	}
+	return buf[shift : shift+size]
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package fio
+
+// NewDirectIOManager 非 Linux 平台不支持 O_DIRECT，退化为标准文件 IO
+func NewDirectIOManager(fileName string) (IOManager, error) {
+	return NewFileIOManager(fileName)
+}
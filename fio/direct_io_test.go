@@ -0,0 +1,99 @@
+package fio
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDirectIOManager(t *testing.T) {
+	dio, err := NewDirectIOManager("a.data")
+	defer destroyFile("a.data")
+	assert.Nil(t, err)
+	assert.NotNil(t, dio)
+
+	err = dio.Close()
+	assert.Nil(t, err)
+}
+
+func TestDirectIO_Read(t *testing.T) {
+	dio, err := NewDirectIOManager("a.data")
+	defer destroyFile("a.data")
+	assert.Nil(t, err)
+	assert.NotNil(t, dio)
+
+	// 空文件
+	b0 := make([]byte, 10)
+	n, err := dio.Read(b0, 0)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+
+	_, err = dio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+	_, err = dio.Write([]byte("key-b"))
+	assert.Nil(t, err)
+
+	b1 := make([]byte, 5)
+	n, err = dio.Read(b1, 0)
+	assert.Equal(t, 5, n)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-a"), b1)
+
+	b2 := make([]byte, 5)
+	n, err = dio.Read(b2, 5)
+	assert.Equal(t, 5, n)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-b"), b2)
+
+	// 读取超过文件末尾
+	b3 := make([]byte, 10)
+	n, err = dio.Read(b3, 5)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, io.EOF, err)
+
+	err = dio.Close()
+	assert.Nil(t, err)
+}
+
+func TestDirectIO_Read_CrossBlock(t *testing.T) {
+	dio, err := NewDirectIOManager("a.data")
+	defer destroyFile("a.data")
+	assert.Nil(t, err)
+	assert.NotNil(t, dio)
+
+	value := bytes.Repeat([]byte("bitcask kv"), 1000)
+	_, err = dio.Write(value)
+	assert.Nil(t, err)
+	err = dio.Sync()
+	assert.Nil(t, err)
+
+	// 跨越多个块的非对齐读取
+	b := make([]byte, 5000)
+	n, err := dio.Read(b, 4090)
+	assert.Equal(t, 5000, n)
+	assert.Nil(t, err)
+	assert.Equal(t, value[4090:9090], b)
+
+	err = dio.Close()
+	assert.Nil(t, err)
+}
+
+func TestReadFile(t *testing.T) {
+	fio, err := NewFileIOManager("a.data")
+	defer destroyFile("a.data")
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("bitcask kv storage"))
+	assert.Nil(t, err)
+	err = fio.Close()
+	assert.Nil(t, err)
+
+	b1, err := ReadFile("a.data", StandardFile, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask kv storage"), b1)
+
+	b2, err := ReadFile("a.data", DirectIO, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask kv storage"), b2)
+}
@@ -0,0 +1,39 @@
+//go:build linux
+
+package fio
+
+import (
+	"errors"
+	"syscall"
+)
+
+const (
+	fallocFlKeepSize  = 0x01 // FALLOC_FL_KEEP_SIZE
+	fallocFlPunchHole = 0x02 // FALLOC_FL_PUNCH_HOLE
+)
+
+// fallocate 使用 FALLOC_FL_KEEP_SIZE 预分配磁盘空间，文件大小保持不变
+// 文件系统不支持时直接忽略
+func fallocate(fd uintptr, size int64) error {
+	err := syscall.Fallocate(int(fd), fallocFlKeepSize, 0, size)
+	if isNotSupported(err) {
+		return nil
+	}
+	return err
+}
+
+// punchHole 释放 [offset, offset+length) 范围内已分配的磁盘空间
+func punchHole(fd uintptr, offset, length int64) error {
+	if length <= 0 {
+		return nil
+	}
+	err := syscall.Fallocate(int(fd), fallocFlKeepSize|fallocFlPunchHole, offset, length)
+	if isNotSupported(err) {
+		return nil
+	}
+	return err
+}
+
+func isNotSupported(err error) bool {
+	return errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package fio
+
+// fallocate 非 Linux 平台不支持预分配，直接忽略
+func fallocate(fd uintptr, size int64) error {
+	return nil
+}
+
+func punchHole(fd uintptr, offset, length int64) error {
+	return nil
+}
@@ -0,0 +1,210 @@
+package fio
+
+import (
+	"container/list"
+	"sync"
+)
+
+// FileCache 文件描述符缓存，最多同时打开 capacity 个文件
+// 超出容量时按照 LRU 策略关闭最久未使用的文件，再次访问时重新打开
+type FileCache struct {
+	capacity int
+	ioType   FileIOType
+	mu       *sync.Mutex
+	lru      *list.List               // 最近使用的文件位于队头
+	entries  map[string]*list.Element // 文件名 -> 已打开的文件
+	hits     uint64                   // 访问时文件已打开的次数
+	misses   uint64                   // 访问时需要重新打开文件的次数
+}
+
+type fileCacheEntry struct {
+	fileName  string
+	ioManager IOManager
+	refs      int  // 正在使用该文件的读写操作数量
+	evicted   bool // 是否已经被淘汰，淘汰后在最后一个使用者释放时关闭
+}
+
+// NewFileCache 初始化文件描述符缓存
+func NewFileCache(capacity int, ioType FileIOType) *FileCache {
+	return &FileCache{
+		capacity: capacity,
+		ioType:   ioType,
+		mu:       new(sync.Mutex),
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Open 返回由缓存按需打开的 IOManager，此时并不会真正打开文件
+func (fc *FileCache) Open(fileName string) IOManager {
+	return &cachedFile{cache: fc, fileName: fileName}
+}
+
+// Stats 返回缓存的命中和未命中次数
+func (fc *FileCache) Stats() (hits uint64, misses uint64) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.hits, fc.misses
+}
+
+// OpenFiles 返回当前打开的文件数量
+func (fc *FileCache) OpenFiles() int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.lru.Len()
+}
+
+// SetIOType 修改之后打开文件使用的 IO 类型，并关闭当前所有已打开的文件
+func (fc *FileCache) SetIOType(ioType FileIOType) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.ioType = ioType
+	var firstErr error
+	for fc.lru.Len() > 0 {
+		if err := fc.evict(fc.lru.Back()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// acquire 获取已打开的文件，文件未打开时重新打开，使用完毕后需要调用 release
+func (fc *FileCache) acquire(fileName string) (*fileCacheEntry, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if elem, ok := fc.entries[fileName]; ok {
+		fc.hits++
+		fc.lru.MoveToFront(elem)
+		entry := elem.Value.(*fileCacheEntry)
+		entry.refs++
+		return entry, nil
+	}
+
+	fc.misses++
+	ioManager, err := NewIOManager(fileName, fc.ioType)
+	if err != nil {
+		return nil, err
+	}
+	entry := &fileCacheEntry{fileName: fileName, ioManager: ioManager, refs: 1}
+	fc.entries[fileName] = fc.lru.PushFront(entry)
+
+	// 超出容量，淘汰最久未使用的文件，关闭失败不影响本次访问
+	for fc.lru.Len() > fc.capacity {
+		_ = fc.evict(fc.lru.Back())
+	}
+	return entry, nil
+}
+
+// release 释放 acquire 获取的文件，文件已被淘汰时将其关闭
+func (fc *FileCache) release(entry *fileCacheEntry) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	entry.refs--
+	if entry.refs == 0 && entry.evicted {
+		return entry.ioManager.Close()
+	}
+	return nil
+}
+
+// remove 将文件从缓存中移除并关闭
+func (fc *FileCache) remove(fileName string) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if elem, ok := fc.entries[fileName]; ok {
+		return fc.evict(elem)
+	}
+	return nil
+}
+
+// evict 淘汰一个文件，没有使用者时立即关闭
+// 在访问此方法前必须持有互斥锁
+func (fc *FileCache) evict(elem *list.Element) error {
+	entry := elem.Value.(*fileCacheEntry)
+	fc.lru.Remove(elem)
+	delete(fc.entries, entry.fileName)
+	entry.evicted = true
+	if entry.refs == 0 {
+		return entry.ioManager.Close()
+	}
+	return nil
+}
+
+// cachedFile 文件描述符由 FileCache 管理的 IOManager
+// 每次操作时从缓存中获取已打开的文件，文件被淘汰后自动重新打开
+type cachedFile struct {
+	cache    *FileCache
+	fileName string
+}
+
+// Read 从文件给定位置读取对应的数据
+func (cf *cachedFile) Read(b []byte, offset int64) (int, error) {
+	var n int
+	err := cf.do(func(ioManager IOManager) error {
+		var err error
+		n, err = ioManager.Read(b, offset)
+		return err
+	})
+	return n, err
+}
+
+// Write 写入字节数组到文件中
+func (cf *cachedFile) Write(b []byte) (int, error) {
+	var n int
+	err := cf.do(func(ioManager IOManager) error {
+		var err error
+		n, err = ioManager.Write(b)
+		return err
+	})
+	return n, err
+}
+
+// Sync 持久化数据
+func (cf *cachedFile) Sync() error {
+	return cf.do(func(ioManager IOManager) error {
+		return ioManager.Sync()
+	})
+}
+
+// Close 关闭文件，并将其从缓存中移除
+func (cf *cachedFile) Close() error {
+	return cf.cache.remove(cf.fileName)
+}
+
+// Size 获取文件大小
+func (cf *cachedFile) Size() (int64, error) {
+	var size int64
+	err := cf.do(func(ioManager IOManager) error {
+		var err error
+		size, err = ioManager.Size()
+		return err
+	})
+	return size, err
+}
+
+// Preallocate 预分配磁盘空间
+func (cf *cachedFile) Preallocate(size int64) error {
+	return cf.do(func(ioManager IOManager) error {
+		return ioManager.Preallocate(size)
+	})
+}
+
+// Truncate 截断文件
+func (cf *cachedFile) Truncate(size int64) error {
+	return cf.do(func(ioManager IOManager) error {
+		return ioManager.Truncate(size)
+	})
+}
+
+// do 获取已打开的文件执行 fn，执行完毕后释放
+func (cf *cachedFile) do(fn func(ioManager IOManager) error) error {
+	entry, err := cf.cache.acquire(cf.fileName)
+	if err != nil {
+		return err
+	}
+	err = fn(entry.ioManager)
+	if errRelease := cf.cache.release(entry); err == nil {
+		err = errRelease
+	}
+	return err
+}
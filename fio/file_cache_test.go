@@ -0,0 +1,90 @@
+package fio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileCache_Read(t *testing.T) {
+	names := []string{"a.data", "b.data", "c.data"}
+	for _, name := range names {
+		fio, err := NewFileIOManager(name)
+		assert.Nil(t, err)
+		_, err = fio.Write([]byte(name))
+		assert.Nil(t, err)
+		err = fio.Close()
+		assert.Nil(t, err)
+		defer destroyFile(name)
+	}
+
+	cache := NewFileCache(2, StandardFile)
+	files := make([]IOManager, len(names))
+	for i, name := range names {
+		files[i] = cache.Open(name)
+	}
+
+	// 依次读取，超出容量后淘汰最久未使用的文件
+	for i, file := range files {
+		b := make([]byte, 6)
+		n, err := file.Read(b, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, 6, n)
+		assert.Equal(t, []byte(names[i]), b)
+	}
+	hits, misses := cache.Stats()
+	assert.Equal(t, uint64(0), hits)
+	assert.Equal(t, uint64(3), misses)
+	assert.Equal(t, 2, cache.lru.Len())
+
+	// 最近使用的文件命中缓存
+	size, err := files[2].Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), size)
+	hits, misses = cache.Stats()
+	assert.Equal(t, uint64(1), hits)
+	assert.Equal(t, uint64(3), misses)
+
+	// 被淘汰的文件重新打开
+	b := make([]byte, 6)
+	_, err = files[0].Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(names[0]), b)
+	hits, misses = cache.Stats()
+	assert.Equal(t, uint64(1), hits)
+	assert.Equal(t, uint64(4), misses)
+
+	for _, file := range files {
+		err := file.Close()
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 0, cache.lru.Len())
+}
+
+func TestFileCache_SetIOType(t *testing.T) {
+	fio, err := NewFileIOManager("a.data")
+	defer destroyFile("a.data")
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("bitcask kv"))
+	assert.Nil(t, err)
+	err = fio.Close()
+	assert.Nil(t, err)
+
+	cache := NewFileCache(2, MemoryMap)
+	file := cache.Open("a.data")
+	size, err := file.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+
+	// 修改 IO 类型后重新打开的文件可以写入
+	err = cache.SetIOType(StandardFile)
+	assert.Nil(t, err)
+	_, err = file.Write([]byte("storage"))
+	assert.Nil(t, err)
+	size, err = file.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(17), size)
+
+	err = file.Close()
+	assert.Nil(t, err)
+}
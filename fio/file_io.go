@@ -4,7 +4,8 @@ import "os"
 
 // FileIO 标准系统文件 IO
 type FileIO struct {
-	fd *os.File // 系统文件描述符
+	fd           *os.File // 系统文件描述符
+	preallocSize int64    // 预分配的磁盘空间大小
 }
 
 // NewFileIOManager 初始化 FileIO
@@ -43,3 +44,27 @@ func (fio *FileIO) Size() (int64, error) {
 	}
 	return stat.Size(), nil
 }
+
+func (fio *FileIO) Preallocate(size int64) error {
+	if err := fallocate(fio.fd.Fd(), size); err != nil {
+		return err
+	}
+	if size > fio.preallocSize {
+		fio.preallocSize = size
+	}
+	return nil
+}
+
+func (fio *FileIO) Truncate(size int64) error {
+	if err := fio.fd.Truncate(size); err != nil {
+		return err
+	}
+	// 部分文件系统在 truncate 后不会回收文件末尾之后的预分配空间，需要手动释放
+	if fio.preallocSize > size {
+		if err := punchHole(fio.fd.Fd(), size, fio.preallocSize-size); err != nil {
+			return err
+		}
+		fio.preallocSize = 0
+	}
+	return nil
+}
@@ -90,3 +90,60 @@ func TestFileIO_Close(t *testing.T) {
 	err = fio.Close()
 	assert.Nil(t, err)
 }
+
+func TestFileIO_Preallocate(t *testing.T) {
+	fio, err := NewFileIOManager("a.data")
+	defer destroyFile("a.data")
+	assert.Nil(t, err)
+	assert.NotNil(t, fio)
+
+	_, err = fio.Write([]byte("bitcask kv"))
+	assert.Nil(t, err)
+
+	// 预分配不改变文件大小
+	err = fio.Preallocate(1024 * 1024)
+	assert.Nil(t, err)
+	size, err := fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+
+	_, err = fio.Write([]byte("storage"))
+	assert.Nil(t, err)
+	size, err = fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(17), size)
+
+	err = fio.Close()
+	assert.Nil(t, err)
+}
+
+func TestFileIO_Truncate(t *testing.T) {
+	fio, err := NewFileIOManager("a.data")
+	defer destroyFile("a.data")
+	assert.Nil(t, err)
+	assert.NotNil(t, fio)
+
+	err = fio.Preallocate(1024 * 1024)
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("bitcask kv"))
+	assert.Nil(t, err)
+	_, err = fio.Write(make([]byte, 32))
+	assert.Nil(t, err)
+
+	err = fio.Truncate(10)
+	assert.Nil(t, err)
+	size, err := fio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), size)
+
+	// 截断后继续追加写入
+	_, err = fio.Write([]byte("storage"))
+	assert.Nil(t, err)
+	b := make([]byte, 7)
+	_, err = fio.Read(b, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("storage"), b)
+
+	err = fio.Close()
+	assert.Nil(t, err)
+}
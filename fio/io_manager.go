@@ -1,5 +1,13 @@
 package fio
 
+import (
+	"errors"
+	"io"
+)
+
+// ErrUnsupportedIOType 不支持的文件 IO 类型
+var ErrUnsupportedIOType = errors.New("unsupported io type")
+
 const DataFilePerm = 0644
 
 type FileIOType = byte
@@ -7,6 +15,7 @@ type FileIOType = byte
 const (
 	StandardFile FileIOType = iota
 	MemoryMap
+	DirectIO // 绕过页缓存的 Direct I/O，仅 Linux 支持，其他平台退化为标准文件 IO
 )
 
 // IOManager 抽象 IO 管理接口，可以接入不同的 IO 类型，目前只支持标准文件 IO
@@ -22,6 +31,12 @@ type IOManager interface {
 
 	// Size 获取文件大小
 	Size() (int64, error)
+
+	// Preallocate 预分配 size 字节的磁盘空间，不改变文件大小
+	Preallocate(size int64) error
+
+	// Truncate 将文件截断到指定大小，并释放之后预分配的空间
+	Truncate(size int64) error
 }
 
 // 初始化 IOManager, 目前只支持标准 FileIO
@@ -31,7 +46,31 @@ func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
 		return NewFileIOManager(fileName)
 	case MemoryMap:
 		return NewMMapIOManager(fileName)
+	case DirectIO:
+		return NewDirectIOManager(fileName)
 	default:
-		panic("unsupported io type")
+		return nil, ErrUnsupportedIOType
+	}
+}
+
+// ReadFile 使用指定的 IO 类型读取整个文件的内容，limiter 不为空时限制读取速率
+func ReadFile(fileName string, ioType FileIOType, limiter *RateLimiter) ([]byte, error) {
+	ioManager, err := NewIOManager(fileName, ioType)
+	if err != nil {
+		return nil, err
+	}
+	defer ioManager.Close()
+	if limiter != nil {
+		ioManager = NewRateLimitedIOManager(ioManager, limiter)
+	}
+
+	size, err := ioManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, size)
+	if _, err := ioManager.Read(b, 0); err != nil && err != io.EOF {
+		return nil, err
 	}
+	return b, nil
 }
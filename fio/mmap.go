@@ -52,3 +52,13 @@ func (mmap *MMap) Close() error {
 func (mmap *MMap) Size() (int64, error) {
 	return int64(mmap.readerAt.Len()), nil
 }
+
+// Preallocate 预分配磁盘空间
+func (mmap *MMap) Preallocate(size int64) error {
+	panic("not implemented")
+}
+
+// Truncate 截断文件
+func (mmap *MMap) Truncate(size int64) error {
+	panic("not implemented")
+}
@@ -0,0 +1,112 @@
+package fio
+
+import (
+	"sync"
+	"time"
+)
+
+// 限速读取时单次读取的最大字节数，避免一次大块读取占满带宽
+const rateLimitChunkSize = 64 * 1024
+
+// RateLimiter 令牌桶限速器，限制每秒读写的字节数
+// 桶的容量为一秒的令牌数，可以在运行时调整速率
+type RateLimiter struct {
+	mu         *sync.Mutex
+	rate       int64     // 每秒允许读写的字节数，小于等于 0 表示不限速
+	tokens     float64   // 当前桶中的令牌数，可以为负数表示预支的令牌
+	lastRefill time.Time // 上一次补充令牌的时间
+}
+
+// NewRateLimiter 初始化限速器
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	return &RateLimiter{
+		mu:         new(sync.Mutex),
+		rate:       bytesPerSec,
+		lastRefill: time.Now(),
+	}
+}
+
+// SetRate 调整每秒允许读写的字节数，小于等于 0 表示不限速
+func (rl *RateLimiter) SetRate(bytesPerSec int64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.refill(time.Now())
+	rl.rate = bytesPerSec
+	if rl.rate > 0 && rl.tokens > float64(rl.rate) {
+		rl.tokens = float64(rl.rate)
+	}
+}
+
+// Rate 返回当前每秒允许读写的字节数
+func (rl *RateLimiter) Rate() int64 {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.rate
+}
+
+// Wait 获取 n 个字节的令牌，令牌不足时阻塞等待
+func (rl *RateLimiter) Wait(n int) {
+	rl.mu.Lock()
+	if rl.rate <= 0 {
+		rl.mu.Unlock()
+		return
+	}
+	rl.refill(time.Now())
+	rl.tokens -= float64(n)
+	var wait time.Duration
+	if rl.tokens < 0 {
+		wait = time.Duration(-rl.tokens / float64(rl.rate) * float64(time.Second))
+	}
+	rl.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// refill 按照经过的时间补充令牌
+// 在访问此方法前必须持有互斥锁
+func (rl *RateLimiter) refill(now time.Time) {
+	if rl.rate > 0 {
+		rl.tokens += now.Sub(rl.lastRefill).Seconds() * float64(rl.rate)
+		if rl.tokens > float64(rl.rate) {
+			rl.tokens = float64(rl.rate)
+		}
+	}
+	rl.lastRefill = now
+}
+
+// RateLimitedIO 读写前从限速器获取令牌的 IOManager
+type RateLimitedIO struct {
+	IOManager
+	limiter *RateLimiter
+}
+
+// NewRateLimitedIOManager 为 ioManager 的读写加上限速
+func NewRateLimitedIOManager(ioManager IOManager, limiter *RateLimiter) *RateLimitedIO {
+	return &RateLimitedIO{IOManager: ioManager, limiter: limiter}
+}
+
+// Read 从文件给定位置读取对应的数据，大块读取会拆分成多次限速读取
+func (rio *RateLimitedIO) Read(b []byte, offset int64) (int, error) {
+	var read int
+	for read < len(b) {
+		end := read + rateLimitChunkSize
+		if end > len(b) {
+			end = len(b)
+		}
+		rio.limiter.Wait(end - read)
+		n, err := rio.IOManager.Read(b[read:end], offset+int64(read))
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+// Write 写入字节数组到文件中
+func (rio *RateLimitedIO) Write(b []byte) (int, error) {
+	rio.limiter.Wait(len(b))
+	return rio.IOManager.Write(b)
+}
@@ -0,0 +1,52 @@
+package fio
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	// 不限速
+	rl := NewRateLimiter(0)
+	start := time.Now()
+	rl.Wait(100 * 1024 * 1024)
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+
+	// 每秒 1MB，读取 200KB 至少需要 200ms
+	rl.SetRate(1024 * 1024)
+	assert.Equal(t, int64(1024*1024), rl.Rate())
+	start = time.Now()
+	rl.Wait(200 * 1024)
+	assert.True(t, time.Since(start) >= 150*time.Millisecond)
+
+	// 运行时取消限速
+	rl.SetRate(0)
+	start = time.Now()
+	rl.Wait(100 * 1024 * 1024)
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+}
+
+func TestRateLimitedIO_Read(t *testing.T) {
+	fio, err := NewFileIOManager("a.data")
+	defer destroyFile("a.data")
+	assert.Nil(t, err)
+	_, err = fio.Write(make([]byte, 200*1024))
+	assert.Nil(t, err)
+
+	rio := NewRateLimitedIOManager(fio, NewRateLimiter(1024*1024))
+	start := time.Now()
+	b := make([]byte, 200*1024)
+	n, err := rio.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 200*1024, n)
+	assert.True(t, time.Since(start) >= 150*time.Millisecond)
+
+	n, err = rio.Write([]byte("bitcask kv"))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, n)
+
+	err = rio.Close()
+	assert.Nil(t, err)
+}
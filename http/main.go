@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 )
 
 var db *bitcask.DB
@@ -74,16 +75,44 @@ func handleDelete(writer http.ResponseWriter, request *http.Request) {
 	_ = json.NewEncoder(writer).Encode("OK!")
 }
 
+// 每页默认返回的 key 数量
+const defaultListKeysLimit = 1000
+
+// handleListKeys 分页返回 key，参数 cursor 为上一页返回的 next_cursor，limit 为每页的数量，prefix 为 key 的前缀
 func handleListKeys(writer http.ResponseWriter, request *http.Request) {
 	if request.Method != http.MethodGet {
 		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	keys := db.ListKey()
+	query := request.URL.Query()
+	limit := defaultListKeysLimit
+	if s := query.Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	opts := bitcask.DefaultIteratorOptions
+	opts.Prefix = []byte(query.Get("prefix"))
+
+	keys, nextCursor, err := db.ScanKeys(query.Get("cursor"), limit, opts)
+	if err == bitcask.ErrInvalidScanCursor || err == bitcask.ErrInvalidScanLimit {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		log.Printf("failed to scan keys in db : %v\n", err)
+		return
+	}
 	writer.Header().Set("Content-Type", "application/json")
-	var result []string
+	result := struct {
+		Keys       []string `json:"keys"`
+		NextCursor string   `json:"next_cursor"`
+	}{Keys: make([]string, 0, len(keys)), NextCursor: nextCursor}
 	for _, key := range keys {
-		result = append(result, string(key))
+		result.Keys = append(result.Keys, string(key))
 	}
 	_ = json.NewEncoder(writer).Encode(result)
 }
@@ -93,11 +122,27 @@ func handleStat(writer http.ResponseWriter, request *http.Request) {
 		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	stat := db.Stat()
+	stat, err := db.Stat()
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	writer.Header().Set("Content-Type", "application/json")
 
 	_ = json.NewEncoder(writer).Encode(stat)
 }
+
+func handleMetrics(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := db.Metrics().WritePrometheus(writer); err != nil {
+		log.Printf("failed to write metrics: %v\n", err)
+	}
+}
+
 func main() {
 
 	// 注册处理方法
@@ -106,6 +151,7 @@ func main() {
 	http.HandleFunc("/delete", handleDelete)
 	http.HandleFunc("/listkeys", handleListKeys)
 	http.HandleFunc("/stat", handleStat)
+	http.HandleFunc("/metrics", handleMetrics)
 	// 启动HTTP服务器
 	if err := http.ListenAndServe("localhost:8080", nil); err != nil {
 		log.Fatalf("failed to listen and serve: %v", err)
@@ -3,23 +3,20 @@ package index
 import (
 	"bitcask-go/data"
 	"bytes"
-	"sort"
 	"sync"
-
-	goart "github.com/plar/go-adaptive-radix-tree"
 )
 
-// 自适应基数树索引
-// 主要封装了 https://github.com/plar/go-adaptive-radix-tree 库
+// AdaptiveRadixTree 自适应基数树索引
+// 查找、写入和删除只与 key 的长度有关，迭代时可以沿着 key 的路径直接定位到 Seek 的位置
 type AdaptiveRadixTree struct {
-	tree goart.Tree
+	root artNode
+	size int
 	lock *sync.RWMutex
 }
 
 // NewART 初始化一个 ART 索引
 func NewART() *AdaptiveRadixTree {
 	return &AdaptiveRadixTree{
-		tree: goart.New(),
 		lock: new(sync.RWMutex),
 	}
 }
@@ -27,42 +24,49 @@ func NewART() *AdaptiveRadixTree {
 // Put 向索引中存储 key 对应的位置信息
 func (art *AdaptiveRadixTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	art.lock.Lock()
-	oldValue, _ := art.tree.Insert(key, pos)
-	art.lock.Unlock()
-	if oldValue == nil {
-		return nil
+	defer art.lock.Unlock()
+	var oldPos *data.LogRecordPos
+	art.root, oldPos = artInsert(art.root, key, 0, pos)
+	if oldPos == nil {
+		art.size++
 	}
-	return oldValue.(*data.LogRecordPos)
+	return oldPos
 }
 
 // Get 根据 key 取出对应的位置信息
 func (art *AdaptiveRadixTree) Get(key []byte) *data.LogRecordPos {
 	art.lock.RLock()
 	defer art.lock.RUnlock()
-	value, found := art.tree.Search(key)
-	if !found {
+	leaf := artSearch(art.root, key)
+	if leaf == nil {
 		return nil
 	}
-	return value.(*data.LogRecordPos)
+	return leaf.pos
 }
 
 // Delete 根据 key 删除对应的位置信息
 func (art *AdaptiveRadixTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	art.lock.Lock()
-	oldValue, deleted := art.tree.Delete(key)
-	art.lock.Unlock()
-	if oldValue == nil {
+	defer art.lock.Unlock()
+	root, oldPos, deleted := artDelete(art.root, key, 0)
+	if !deleted {
 		return nil, false
 	}
-	return oldValue.(*data.LogRecordPos), deleted
+	art.root = root
+	art.size--
+	return oldPos, true
+}
+
+// ApplyBatch 按顺序批量更新索引
+func (art *AdaptiveRadixTree) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	return applyBatch(art, ops)
 }
 
 // Size 索引中的数据量
 func (art *AdaptiveRadixTree) Size() int {
 	art.lock.RLock()
-	size := art.tree.Size()
-	art.lock.RUnlock()
-	return size
+	defer art.lock.RUnlock()
+	return art.size
 }
 
 func (art *AdaptiveRadixTree) Close() error {
@@ -70,79 +74,69 @@ func (art *AdaptiveRadixTree) Close() error {
 }
 
 // Iterator 初始化一个迭代器，用于遍历索引中的 key
+// 迭代器不会拷贝数据，每次移动时沿着树的路径定位到下一个 key
 func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
-	art.lock.RLock()
-	defer art.lock.RUnlock()
-	return newARTIterator(art.tree, reverse)
+	ai := &artIterator{art: art, reverse: reverse}
+	ai.Rewind()
+	return ai
 }
 
 // artIterator 自适应基数树迭代器
+// 每次移动时持有读锁，从当前的 key 沿着树的路径找到下一个 key，
+// 遍历过程中树被修改也不会重复或遗漏没有被删除的 key
 type artIterator struct {
-	currIndex int     // 当前遍历的下标位置
-	reverse   bool    // 是否是反向遍历
-	values    []*Item // key+位置索引信息
-}
-
-func newARTIterator(tree goart.Tree, reverse bool) *artIterator {
-	var idx int
-	if reverse {
-		idx = tree.Size() - 1
-	}
-	values := make([]*Item, tree.Size())
-
-	savevalues := func(node goart.Node) bool {
-		item := &Item{
-			key: node.Key(),
-			pos: node.Value().(*data.LogRecordPos),
-		}
-		values[idx] = item
-		if reverse {
-			idx--
-		} else {
-			idx++
-		}
-		return true
-	}
-	tree.ForEach(savevalues)
-	return &artIterator{
-		currIndex: 0,
-		reverse:   reverse,
-		values:    values,
-	}
+	art     *AdaptiveRadixTree
+	reverse bool               // 是否是反向遍历
+	key     []byte             // 当前遍历到的 key，为空表示遍历结束
+	pos     *data.LogRecordPos // 当前 key 的位置信息
 }
 
 func (ai *artIterator) Rewind() {
-	ai.currIndex = 0
+	ai.seek(nil, false)
 }
 
 func (ai *artIterator) Seek(key []byte) {
-	if ai.reverse {
-		ai.currIndex = sort.Search(len(ai.values), func(i int) bool {
-			return bytes.Compare(ai.values[i].key, key) <= 0
-		})
-	} else {
-		ai.currIndex = sort.Search(len(ai.values), func(i int) bool {
-			return bytes.Compare(ai.values[i].key, key) >= 0
-		})
-	}
+	ai.seek(key, false)
 }
 
 func (ai *artIterator) Next() {
-	ai.currIndex += 1
+	if ai.key == nil {
+		return
+	}
+	ai.seek(ai.key, true)
 }
 
 func (ai *artIterator) Valid() bool {
-	return ai.currIndex < len(ai.values)
+	return ai.key != nil
 }
 
 func (ai *artIterator) Key() []byte {
-	return ai.values[ai.currIndex].key
+	return ai.key
 }
 
 func (ai *artIterator) Value() *data.LogRecordPos {
-	return ai.values[ai.currIndex].pos
+	return ai.pos
 }
 
 func (ai *artIterator) Close() {
-	ai.values = nil
+	ai.art = nil
+	ai.key = nil
+	ai.pos = nil
+}
+
+// seek 定位到 pivot(为空时从头开始)之后的第一个 key，skipPivot 表示不包括 pivot 本身
+func (ai *artIterator) seek(pivot []byte, skipPivot bool) {
+	ai.key, ai.pos = nil, nil
+	if ai.art == nil {
+		return
+	}
+	ai.art.lock.RLock()
+	defer ai.art.lock.RUnlock()
+	artWalk(ai.art.root, 0, pivot, ai.reverse, func(leaf *artLeaf) bool {
+		if skipPivot && bytes.Equal(leaf.key, pivot) {
+			return true
+		}
+		ai.key, ai.pos = leaf.key, leaf.pos
+		return false
+	})
 }
@@ -0,0 +1,510 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"sort"
+)
+
+// 自适应基数树的节点
+// 内部节点按照子节点的数量在 node4、node16、node48 和 node256 四种布局之间切换，
+// 只有一个子节点的路径会被压缩到节点的前缀中，叶子节点保存完整的 key。
+// 一个 key 是另一个 key 的前缀时，较短的 key 保存在路径末端内部节点的 leaf 中
+
+// 内部节点的布局
+const (
+	artNode4 uint8 = iota
+	artNode16
+	artNode48
+	artNode256
+)
+
+// artNode 基数树的节点，只能是 *artLeaf 或者 *artInner
+type artNode interface{}
+
+// artLeaf 叶子节点
+type artLeaf struct {
+	key []byte
+	pos *data.LogRecordPos
+}
+
+// artInner 内部节点
+type artInner struct {
+	prefix   []byte    // 路径压缩之后当前节点的公共前缀
+	leaf     *artLeaf  // 恰好在当前节点结束的 key
+	kind     uint8     // 节点的布局
+	num      int       // 子节点的数量
+	keys     []byte    // node4、node16 中有序排列的子节点字节，node48 中字节对应的子节点下标加一
+	children []artNode // 子节点，node256 中按照字节存放
+}
+
+func newArtNode4(prefix []byte) *artInner {
+	return &artInner{
+		prefix:   prefix,
+		kind:     artNode4,
+		keys:     make([]byte, 0, 4),
+		children: make([]artNode, 0, 4),
+	}
+}
+
+// findChild 查找字节 b 对应的子节点
+func (n *artInner) findChild(b byte) artNode {
+	switch n.kind {
+	case artNode4, artNode16:
+		if i := n.search(b); i < n.num && n.keys[i] == b {
+			return n.children[i]
+		}
+		return nil
+	case artNode48:
+		if idx := n.keys[b]; idx != 0 {
+			return n.children[idx-1]
+		}
+		return nil
+	default:
+		return n.children[b]
+	}
+}
+
+// search node4、node16 中第一个不小于 b 的子节点下标
+func (n *artInner) search(b byte) int {
+	return sort.Search(n.num, func(i int) bool { return n.keys[i] >= b })
+}
+
+// setChild 替换字节 b 对应的已有子节点
+func (n *artInner) setChild(b byte, child artNode) {
+	switch n.kind {
+	case artNode4, artNode16:
+		n.children[n.search(b)] = child
+	case artNode48:
+		n.children[n.keys[b]-1] = child
+	default:
+		n.children[b] = child
+	}
+}
+
+// addChild 添加字节 b 对应的子节点，子节点已满时切换到更大的布局
+func (n *artInner) addChild(b byte, child artNode) {
+	switch n.kind {
+	case artNode4, artNode16:
+		if n.num == cap(n.keys) {
+			n.grow()
+			n.addChild(b, child)
+			return
+		}
+		i := n.search(b)
+		n.keys = append(n.keys, 0)
+		n.children = append(n.children, nil)
+		copy(n.keys[i+1:], n.keys[i:])
+		copy(n.children[i+1:], n.children[i:])
+		n.keys[i], n.children[i] = b, child
+	case artNode48:
+		if n.num == 48 {
+			n.grow()
+			n.addChild(b, child)
+			return
+		}
+		// 删除子节点会留下空位，优先复用
+		slot := len(n.children)
+		for i, c := range n.children {
+			if c == nil {
+				slot = i
+				break
+			}
+		}
+		if slot == len(n.children) {
+			n.children = append(n.children, child)
+		} else {
+			n.children[slot] = child
+		}
+		n.keys[b] = byte(slot + 1)
+	default:
+		n.children[b] = child
+	}
+	n.num++
+}
+
+// removeChild 删除字节 b 对应的子节点，子节点太少时切换到更小的布局
+func (n *artInner) removeChild(b byte) {
+	switch n.kind {
+	case artNode4, artNode16:
+		i := n.search(b)
+		copy(n.keys[i:], n.keys[i+1:])
+		copy(n.children[i:], n.children[i+1:])
+		n.keys = n.keys[:n.num-1]
+		n.children[n.num-1] = nil
+		n.children = n.children[:n.num-1]
+	case artNode48:
+		n.children[n.keys[b]-1] = nil
+		n.keys[b] = 0
+	default:
+		n.children[b] = nil
+	}
+	n.num--
+	n.shrink()
+}
+
+// grow 切换到能容纳更多子节点的布局
+func (n *artInner) grow() {
+	switch n.kind {
+	case artNode4:
+		keys, children := make([]byte, n.num, 16), make([]artNode, n.num, 16)
+		copy(keys, n.keys)
+		copy(children, n.children)
+		n.kind, n.keys, n.children = artNode16, keys, children
+	case artNode16:
+		keys, children := make([]byte, 256), make([]artNode, n.num, 48)
+		for i := 0; i < n.num; i++ {
+			keys[n.keys[i]] = byte(i + 1)
+			children[i] = n.children[i]
+		}
+		n.kind, n.keys, n.children = artNode48, keys, children
+	case artNode48:
+		children := make([]artNode, 256)
+		for b, idx := range n.keys {
+			if idx != 0 {
+				children[b] = n.children[idx-1]
+			}
+		}
+		n.kind, n.keys, n.children = artNode256, nil, children
+	}
+}
+
+// shrink 子节点明显少于当前布局的容量时切换到更小的布局，留出余量避免反复切换
+func (n *artInner) shrink() {
+	switch {
+	case n.kind == artNode256 && n.num <= 36:
+		keys, children := make([]byte, 256), make([]artNode, 0, 48)
+		for b, c := range n.children {
+			if c != nil {
+				children = append(children, c)
+				keys[b] = byte(len(children))
+			}
+		}
+		n.kind, n.keys, n.children = artNode48, keys, children
+	case n.kind == artNode48 && n.num <= 12:
+		keys, children := make([]byte, 0, 16), make([]artNode, 0, 16)
+		for b, idx := range n.keys {
+			if idx != 0 {
+				keys = append(keys, byte(b))
+				children = append(children, n.children[idx-1])
+			}
+		}
+		n.kind, n.keys, n.children = artNode16, keys, children
+	case n.kind == artNode16 && n.num <= 3:
+		keys, children := make([]byte, n.num, 4), make([]artNode, n.num, 4)
+		copy(keys, n.keys)
+		copy(children, n.children)
+		n.kind, n.keys, n.children = artNode4, keys, children
+	}
+}
+
+// eachChild 按顺序遍历子节点，正向遍历字节大于等于 from 的子节点，反向遍历字节小于等于 from 的子节点
+// fn 返回 false 时停止遍历，此时返回 false
+func (n *artInner) eachChild(from int, reverse bool, fn func(b byte, child artNode) bool) bool {
+	switch n.kind {
+	case artNode4, artNode16:
+		if reverse {
+			for i := n.num - 1; i >= 0; i-- {
+				if int(n.keys[i]) <= from && !fn(n.keys[i], n.children[i]) {
+					return false
+				}
+			}
+			return true
+		}
+		for i := 0; i < n.num; i++ {
+			if int(n.keys[i]) >= from && !fn(n.keys[i], n.children[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		child := func(b int) artNode {
+			if n.kind == artNode256 {
+				return n.children[b]
+			}
+			if idx := n.keys[b]; idx != 0 {
+				return n.children[idx-1]
+			}
+			return nil
+		}
+		if reverse {
+			for b := from; b >= 0; b-- {
+				if c := child(b); c != nil && !fn(byte(b), c) {
+					return false
+				}
+			}
+			return true
+		}
+		for b := from; b < 256; b++ {
+			if c := child(b); c != nil && !fn(byte(b), c) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// artSearch 查找 key 对应的叶子节点
+func artSearch(n artNode, key []byte) *artLeaf {
+	depth := 0
+	for {
+		switch node := n.(type) {
+		case *artLeaf:
+			if bytes.Equal(node.key, key) {
+				return node
+			}
+			return nil
+		case *artInner:
+			if !bytes.HasPrefix(key[depth:], node.prefix) {
+				return nil
+			}
+			depth += len(node.prefix)
+			if depth == len(key) {
+				return node.leaf
+			}
+			n = node.findChild(key[depth])
+			depth++
+		default:
+			return nil
+		}
+	}
+}
+
+// artInsert 在子树中写入 key，返回替换之后的子树根节点和 key 原来的位置信息
+func artInsert(n artNode, key []byte, depth int, pos *data.LogRecordPos) (artNode, *data.LogRecordPos) {
+	switch node := n.(type) {
+	case *artLeaf:
+		if bytes.Equal(node.key, key) {
+			oldPos := node.pos
+			node.pos = pos
+			return node, oldPos
+		}
+		// 两个 key 从公共前缀之后分叉
+		common := commonPrefixLen(node.key[depth:], key[depth:])
+		inner := newArtNode4(key[depth : depth+common : depth+common])
+		inner.addLeaf(node, depth+common)
+		inner.addLeaf(&artLeaf{key: key, pos: pos}, depth+common)
+		return inner, nil
+	case *artInner:
+		common := commonPrefixLen(node.prefix, key[depth:])
+		if common < len(node.prefix) {
+			// key 在前缀的中间分叉，拆分前缀
+			parent := newArtNode4(node.prefix[:common:common])
+			parent.addChild(node.prefix[common], node)
+			node.prefix = node.prefix[common+1:]
+			parent.addLeaf(&artLeaf{key: key, pos: pos}, depth+common)
+			return parent, nil
+		}
+		depth += len(node.prefix)
+		if depth == len(key) {
+			if node.leaf == nil {
+				node.leaf = &artLeaf{key: key, pos: pos}
+				return node, nil
+			}
+			oldPos := node.leaf.pos
+			node.leaf.pos = pos
+			return node, oldPos
+		}
+		child := node.findChild(key[depth])
+		if child == nil {
+			node.addChild(key[depth], &artLeaf{key: key, pos: pos})
+			return node, nil
+		}
+		newChild, oldPos := artInsert(child, key, depth+1, pos)
+		if newChild != child {
+			node.setChild(key[depth], newChild)
+		}
+		return node, oldPos
+	default:
+		return &artLeaf{key: key, pos: pos}, nil
+	}
+}
+
+// addLeaf 将叶子节点挂到内部节点上，depth 为内部节点之后的 key 的下标
+func (n *artInner) addLeaf(leaf *artLeaf, depth int) {
+	if len(leaf.key) == depth {
+		n.leaf = leaf
+		return
+	}
+	n.addChild(leaf.key[depth], leaf)
+}
+
+// artDelete 在子树中删除 key，返回替换之后的子树根节点，子树为空时返回 nil
+func artDelete(n artNode, key []byte, depth int) (artNode, *data.LogRecordPos, bool) {
+	switch node := n.(type) {
+	case *artLeaf:
+		if bytes.Equal(node.key, key) {
+			return nil, node.pos, true
+		}
+		return node, nil, false
+	case *artInner:
+		if !bytes.HasPrefix(key[depth:], node.prefix) {
+			return node, nil, false
+		}
+		depth += len(node.prefix)
+		if depth == len(key) {
+			if node.leaf == nil {
+				return node, nil, false
+			}
+			oldPos := node.leaf.pos
+			node.leaf = nil
+			return node.collapse(), oldPos, true
+		}
+		b := key[depth]
+		child := node.findChild(b)
+		if child == nil {
+			return node, nil, false
+		}
+		newChild, oldPos, ok := artDelete(child, key, depth+1)
+		if !ok {
+			return node, nil, false
+		}
+		if newChild == nil {
+			node.removeChild(b)
+		} else if newChild != child {
+			node.setChild(b, newChild)
+		}
+		return node.collapse(), oldPos, true
+	default:
+		return nil, nil, false
+	}
+}
+
+// collapse 删除之后只剩一个叶子或者一个子节点时，用它替换当前节点
+func (n *artInner) collapse() artNode {
+	switch {
+	case n.num == 0:
+		if n.leaf == nil {
+			return nil
+		}
+		return n.leaf
+	case n.num == 1 && n.leaf == nil:
+		var b byte
+		var child artNode
+		n.eachChild(0, false, func(cb byte, c artNode) bool {
+			b, child = cb, c
+			return false
+		})
+		inner, ok := child.(*artInner)
+		if !ok {
+			return child
+		}
+		// 合并前缀：当前节点的前缀 + 子节点的字节 + 子节点的前缀
+		prefix := make([]byte, 0, len(n.prefix)+1+len(inner.prefix))
+		prefix = append(prefix, n.prefix...)
+		prefix = append(prefix, b)
+		inner.prefix = append(prefix, inner.prefix...)
+		return inner
+	default:
+		return n
+	}
+}
+
+// artWalk 按顺序遍历子树中的叶子节点，fn 返回 false 时停止遍历，此时返回 false
+// bound 为空时遍历整个子树，否则正向遍历时跳过小于 bound 的 key，反向遍历时跳过大于 bound 的 key，
+// 只会沿着 bound 所在的路径向下查找，不需要从头遍历
+func artWalk(n artNode, depth int, bound []byte, reverse bool, fn func(leaf *artLeaf) bool) bool {
+	switch node := n.(type) {
+	case *artLeaf:
+		if bound != nil {
+			c := bytes.Compare(node.key, bound)
+			if (!reverse && c < 0) || (reverse && c > 0) {
+				return true
+			}
+		}
+		return fn(node)
+	case *artInner:
+		if bound != nil {
+			// 子树中所有 key 都以 bound[:depth] + prefix 开头，比较前缀即可确定整个子树和 bound 的关系
+			switch comparePrefix(node.prefix, bound[depth:]) {
+			case 1:
+				if reverse {
+					return true
+				}
+				bound = nil
+			case -1:
+				if !reverse {
+					return true
+				}
+				bound = nil
+			}
+		}
+		depth += len(node.prefix)
+		walkLeaf := func() bool {
+			if node.leaf == nil {
+				return true
+			}
+			return artWalk(node.leaf, depth, bound, reverse, fn)
+		}
+
+		if bound == nil || depth == len(bound) {
+			// 子节点中的 key 都以 bound 开头并且更长，都大于 bound
+			if reverse {
+				if bound == nil && !node.eachChild(255, true, func(_ byte, child artNode) bool {
+					return artWalk(child, depth+1, nil, true, fn)
+				}) {
+					return false
+				}
+				return walkLeaf()
+			}
+			if !walkLeaf() {
+				return false
+			}
+			return node.eachChild(0, false, func(_ byte, child artNode) bool {
+				return artWalk(child, depth+1, nil, false, fn)
+			})
+		}
+
+		// bound 在子节点中，只有 bound 所在的子节点需要继续比较
+		b := bound[depth]
+		if reverse {
+			if !node.eachChild(int(b), true, func(cb byte, child artNode) bool {
+				if cb == b {
+					return artWalk(child, depth+1, bound, true, fn)
+				}
+				return artWalk(child, depth+1, nil, true, fn)
+			}) {
+				return false
+			}
+			// 当前节点的 key 是 bound 的前缀，小于 bound
+			return walkLeaf()
+		}
+		return node.eachChild(int(b), false, func(cb byte, child artNode) bool {
+			if cb == b {
+				return artWalk(child, depth+1, bound, false, fn)
+			}
+			return artWalk(child, depth+1, nil, false, fn)
+		})
+	default:
+		return true
+	}
+}
+
+// comparePrefix 比较节点的前缀和 key 的剩余部分
+// 前缀和 key 在前缀的长度内相同时返回 0；key 是前缀的真前缀时，子树中的 key 都更大，返回 1
+func comparePrefix(prefix, key []byte) int {
+	n := len(prefix)
+	if len(key) < n {
+		n = len(key)
+	}
+	if c := bytes.Compare(prefix[:n], key[:n]); c != 0 {
+		return c
+	}
+	if len(key) < len(prefix) {
+		return 1
+	}
+	return 0
+}
+
+func commonPrefixLen(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
@@ -2,6 +2,10 @@ package index
 
 import (
 	"bitcask-go/data"
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -71,3 +75,358 @@ func TestAdaptiveRadixTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestAdaptiveRadixTree_IteratorModify(t *testing.T) {
+	art := NewART()
+	for i := 0; i < 100; i++ {
+		art.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	// 遍历的同时修改索引，不会重复或遗漏未被删除的 key
+	iter := art.Iterator(false)
+	var i int
+	for iter.Seek([]byte("key-010")); iter.Valid(); iter.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i+10)), iter.Key())
+		if i == 20 {
+			art.Delete([]byte("key-031"))
+			art.Put([]byte("key-001"), &data.LogRecordPos{Fid: 2})
+			i++
+		}
+		i++
+	}
+	assert.Equal(t, 90, i)
+	iter.Close()
+}
+
+func TestAdaptiveRadixTree_Seek(t *testing.T) {
+	art := NewART()
+	// 随机写入和删除较短的 key，覆盖前缀压缩、一个 key 是另一个 key 的前缀以及各种节点布局
+	r := rand.New(rand.NewSource(1))
+	randKey := func() []byte {
+		key := make([]byte, r.Intn(4))
+		for i := range key {
+			key[i] = "ab\x00\xff"[r.Intn(4)]
+		}
+		if r.Intn(2) == 0 {
+			key = append(key, byte(r.Intn(256)))
+		}
+		return key
+	}
+	expected := make(map[string]int64)
+	// 同一个前缀下有 256 个子节点，写入和删除时经过所有的节点布局
+	for i := 0; i < 256; i++ {
+		key := []byte{'b', byte(i)}
+		art.Put(key, &data.LogRecordPos{Offset: int64(i)})
+		expected[string(key)] = int64(i)
+	}
+	for i := 0; i < 5000; i++ {
+		key := randKey()
+		if len(key) == 0 {
+			continue
+		}
+		if r.Intn(3) == 0 {
+			_, ok := expected[string(key)]
+			_, deleted := art.Delete(key)
+			assert.Equal(t, ok, deleted)
+			delete(expected, string(key))
+		} else {
+			art.Put(key, &data.LogRecordPos{Offset: int64(i)})
+			expected[string(key)] = int64(i)
+		}
+	}
+	assert.Equal(t, len(expected), art.Size())
+
+	keys := make([]string, 0, len(expected))
+	for key, offset := range expected {
+		keys = append(keys, key)
+		assert.Equal(t, offset, art.Get([]byte(key)).Offset)
+	}
+	sort.Strings(keys)
+
+	collect := func(iter Iterator) []string {
+		got := []string{}
+		for ; iter.Valid(); iter.Next() {
+			got = append(got, string(iter.Key()))
+		}
+		return got
+	}
+	iter := art.Iterator(false)
+	assert.Equal(t, keys, collect(iter))
+	reverseIter := art.Iterator(true)
+	reversed := []string{}
+	for i := len(keys) - 1; i >= 0; i-- {
+		reversed = append(reversed, keys[i])
+	}
+	assert.Equal(t, reversed, collect(reverseIter))
+
+	for i := 0; i < 500; i++ {
+		target := randKey()
+		idx := sort.SearchStrings(keys, string(target))
+		iter.Seek(target)
+		assert.Equal(t, keys[idx:], collect(iter))
+
+		// 反向遍历定位到小于等于 target 的最大的 key
+		idx = sort.Search(len(keys), func(i int) bool { return bytes.Compare([]byte(keys[i]), target) > 0 })
+		reverseIter.Seek(target)
+		assert.Equal(t, reversed[len(reversed)-idx:], collect(reverseIter))
+		reverseIter.Seek(target)
+		if idx > 0 {
+			assert.Equal(t, keys[idx-1], string(reverseIter.Key()))
+		} else {
+			assert.False(t, reverseIter.Valid())
+		}
+	}
+
+	// 删除所有 key 之后树为空
+	for _, key := range keys {
+		_, ok := art.Delete([]byte(key))
+		assert.True(t, ok)
+	}
+	assert.Equal(t, 0, art.Size())
+	assert.Nil(t, art.root)
+}
+
+// checkArtNode 检查子树的结构：子节点数量与布局匹配、不存在可以合并的节点、叶子的 key 与路径一致
+// 返回子树中的叶子数量，kinds 记录出现过的节点布局
+func checkArtNode(t *testing.T, n artNode, path []byte, kinds map[uint8]bool) int {
+	switch node := n.(type) {
+	case *artLeaf:
+		assert.True(t, bytes.HasPrefix(node.key, path), "leaf %q under path %q", node.key, path)
+		return 1
+	case *artInner:
+		kinds[node.kind] = true
+		path = append(path[:len(path):len(path)], node.prefix...)
+		count := 0
+		if node.leaf != nil {
+			assert.Equal(t, path, node.leaf.key)
+			count++
+		}
+		num := 0
+		node.eachChild(0, false, func(b byte, child artNode) bool {
+			num++
+			count += checkArtNode(t, child, append(path[:len(path):len(path)], b), kinds)
+			return true
+		})
+		assert.Equal(t, node.num, num)
+		switch node.kind {
+		case artNode4:
+			assert.LessOrEqual(t, num, 4)
+		case artNode16:
+			assert.True(t, num > 3 && num <= 16, "node16 with %d children", num)
+		case artNode48:
+			assert.True(t, num > 12 && num <= 48, "node48 with %d children", num)
+		case artNode256:
+			assert.Greater(t, num, 36)
+		}
+		// 没有子节点，或者只有一个子节点且没有叶子的节点应该在删除时被合并
+		assert.False(t, num == 0 || (num == 1 && node.leaf == nil), "uncollapsed node at %q", path)
+		return count
+	default:
+		assert.Nil(t, n)
+		return 0
+	}
+}
+
+func TestAdaptiveRadixTree_NodeLayouts(t *testing.T) {
+	art := NewART()
+	kindOf := func(children int) uint8 {
+		switch {
+		case children <= 4:
+			return artNode4
+		case children <= 16:
+			return artNode16
+		case children <= 48:
+			return artNode48
+		default:
+			return artNode256
+		}
+	}
+	// 子节点逐个增加，依次切换到 node4、node16、node48 和 node256
+	for i := 0; i < 256; i++ {
+		art.Put([]byte{'p', byte(i)}, &data.LogRecordPos{Offset: int64(i)})
+		if i == 0 {
+			continue
+		}
+		root := art.root.(*artInner)
+		assert.Equal(t, []byte("p"), root.prefix)
+		assert.Equal(t, kindOf(i+1), root.kind, "children %d", i+1)
+	}
+	for i := 0; i < 256; i++ {
+		assert.Equal(t, int64(i), art.Get([]byte{'p', byte(i)}).Offset)
+	}
+
+	// 子节点逐个减少，在留出余量之后依次切换回更小的布局
+	shrunk := func(children int) uint8 {
+		switch {
+		case children <= 3:
+			return artNode4
+		case children <= 12:
+			return artNode16
+		case children <= 36:
+			return artNode48
+		default:
+			return artNode256
+		}
+	}
+	for i := 255; i > 0; i-- {
+		_, ok := art.Delete([]byte{'p', byte(i)})
+		assert.True(t, ok)
+		if i == 1 {
+			break
+		}
+		root := art.root.(*artInner)
+		assert.Equal(t, shrunk(i), root.kind, "children %d", i)
+		checkArtNode(t, art.root, nil, map[uint8]bool{})
+		for j := 0; j < 256; j++ {
+			pos := art.Get([]byte{'p', byte(j)})
+			if j < i {
+				assert.Equal(t, int64(j), pos.Offset)
+			} else {
+				assert.Nil(t, pos)
+			}
+		}
+	}
+	// 只剩一个 key 时内部节点被替换成叶子
+	leaf, ok := art.root.(*artLeaf)
+	assert.True(t, ok)
+	assert.Equal(t, []byte{'p', 0}, leaf.key)
+}
+
+func TestAdaptiveRadixTree_PrefixSplitMerge(t *testing.T) {
+	art := NewART()
+	put := func(key string) {
+		art.Put([]byte(key), &data.LogRecordPos{Offset: int64(len(key))})
+	}
+	put("abcdef-1")
+	put("abcdef-2")
+	root := art.root.(*artInner)
+	assert.Equal(t, []byte("abcdef-"), root.prefix)
+
+	// 在前缀中间分叉时拆分前缀
+	put("abcx")
+	root = art.root.(*artInner)
+	assert.Equal(t, []byte("abc"), root.prefix)
+	child := root.findChild('d').(*artInner)
+	assert.Equal(t, []byte("ef-"), child.prefix)
+
+	// key 是其它 key 的前缀时保存在内部节点上
+	put("abc")
+	assert.NotNil(t, art.root.(*artInner).leaf)
+	put("abcdef-")
+	assert.NotNil(t, child.leaf)
+	checkArtNode(t, art.root, nil, map[uint8]bool{})
+
+	// 删除之后只剩一个子节点时合并前缀
+	_, ok := art.Delete([]byte("abcx"))
+	assert.True(t, ok)
+	_, ok = art.Delete([]byte("abc"))
+	assert.True(t, ok)
+	root = art.root.(*artInner)
+	assert.Equal(t, []byte("abcdef-"), root.prefix)
+	assert.Equal(t, []byte("abcdef-"), root.leaf.key)
+	checkArtNode(t, art.root, nil, map[uint8]bool{})
+
+	_, ok = art.Delete([]byte("abcdef-"))
+	assert.True(t, ok)
+	_, ok = art.Delete([]byte("abcdef-1"))
+	assert.True(t, ok)
+	leaf := art.root.(*artLeaf)
+	assert.Equal(t, []byte("abcdef-2"), leaf.key)
+	assert.Equal(t, int64(8), art.Get([]byte("abcdef-2")).Offset)
+	assert.Nil(t, art.Get([]byte("abcdef-")))
+	assert.Nil(t, art.Get([]byte("abc")))
+}
+
+// 随机写入和删除，与 BTree 索引比较查找、遍历和 Seek 的结果
+func TestAdaptiveRadixTree_Differential(t *testing.T) {
+	collect := func(iter Iterator) []string {
+		var got []string
+		for ; iter.Valid(); iter.Next() {
+			got = append(got, fmt.Sprintf("%q=%d", iter.Key(), iter.Value().Offset))
+		}
+		return got
+	}
+	for seed := int64(1); seed <= 5; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		// 公共前缀较长的 key 用于拆分和合并前缀，单字节后缀覆盖所有的节点布局
+		prefixes := [][]byte{nil, []byte("a"), []byte("ab"), []byte("abcdefgh"), []byte("abcdefgz"), {0xff, 0xff}}
+		randKey := func() []byte {
+			key := append([]byte{}, prefixes[r.Intn(len(prefixes))]...)
+			switch r.Intn(3) {
+			case 0:
+				key = append(key, byte(r.Intn(256)))
+			case 1:
+				for i := r.Intn(4); i > 0; i-- {
+					key = append(key, "ab\x00\xff"[r.Intn(4)])
+				}
+			}
+			return key
+		}
+
+		art, bt := NewART(), NewBTree()
+		kinds := make(map[uint8]bool)
+		for round := 0; round < 20; round++ {
+			// 前半部分以写入为主让节点增长，后半部分以删除为主让节点收缩
+			deleteRatio := 0.2
+			if round >= 10 {
+				deleteRatio = 0.8
+			}
+			for i := 0; i < 500; i++ {
+				key := randKey()
+				if len(key) == 0 {
+					continue
+				}
+				if r.Float64() < deleteRatio {
+					artOld, artOk := art.Delete(key)
+					btOld, btOk := bt.Delete(key)
+					assert.Equal(t, btOk, artOk)
+					assert.Equal(t, btOld, artOld)
+				} else {
+					pos := &data.LogRecordPos{Fid: uint32(seed), Offset: int64(round*500 + i)}
+					assert.Equal(t, bt.Put(key, pos), art.Put(key, pos))
+				}
+				if i%25 == 0 {
+					assert.Equal(t, bt.Size(), checkArtNode(t, art.root, nil, kinds))
+				}
+			}
+
+			assert.Equal(t, bt.Size(), art.Size())
+			assert.Equal(t, art.Size(), checkArtNode(t, art.root, nil, kinds))
+			for i := 0; i < 200; i++ {
+				key := randKey()
+				assert.Equal(t, bt.Get(key), art.Get(key), "key %q", key)
+			}
+
+			for _, reverse := range []bool{false, true} {
+				artIter, btIter := art.Iterator(reverse), bt.Iterator(reverse)
+				assert.Equal(t, collect(btIter), collect(artIter))
+				for i := 0; i < 50; i++ {
+					target := randKey()
+					artIter.Seek(target)
+					btIter.Seek(target)
+					assert.Equal(t, collect(btIter), collect(artIter), "seek %q reverse %v", target, reverse)
+				}
+				artIter.Rewind()
+				btIter.Rewind()
+				assert.Equal(t, collect(btIter), collect(artIter))
+				artIter.Close()
+				btIter.Close()
+			}
+		}
+		assert.True(t, kinds[artNode4] && kinds[artNode16] && kinds[artNode48] && kinds[artNode256], "seed %d kinds %v", seed, kinds)
+
+		// 删除所有 key 之后两个索引都为空
+		iter := bt.Iterator(false)
+		var keys [][]byte
+		for ; iter.Valid(); iter.Next() {
+			keys = append(keys, iter.Key())
+		}
+		iter.Close()
+		for _, key := range keys {
+			_, ok := art.Delete(key)
+			assert.True(t, ok)
+		}
+		assert.Equal(t, 0, art.Size())
+		assert.Nil(t, art.root)
+	}
+}
@@ -2,52 +2,240 @@ package index
 
 import (
 	"bitcask-go/data"
+	"bytes"
+	"encoding/binary"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"go.etcd.io/bbolt"
 )
 
-const bptreeIndexFileName = "bptree-index"
+// BPTreeIndexFileName B+ 树索引文件的名称
+const BPTreeIndexFileName = "bptree-index"
 
-var indexBucketName = []byte("bitcask-index")
+var (
+	indexBucketName = []byte("bitcask-index")
+	// 保存检查点等元数据的 bucket
+	metaBucketName = []byte("bitcask-meta")
+	// 保存检查点之后被修改的 key 在检查点时的位置信息，用于崩溃后撤销这些修改
+	undoBucketName = []byte("bitcask-undo")
+
+	checkpointKey = []byte("checkpoint")
+)
+
+// undo 记录的类型
+const (
+	undoAbsent  byte = iota // 检查点时 key 不存在
+	undoPresent             // 检查点时 key 存在，后面是编码后的位置信息
+)
 
 // BPlusTree B+树索引
 // 主要封装了 go.etcd.io/bbolt 库
+//
+// 索引会记录一个检查点，表示检查点之前的数据文件已经持久化并且全部更新到了索引中。
+// 检查点之后每个 key 第一次被修改时，会在同一个事务中记录它在检查点时的位置信息，
+// 重新打开时先撤销检查点之后的所有修改，再从检查点开始重放数据文件，
+// 这样崩溃后索引不会指向没有持久化的数据。
+//
+// 读写 bbolt 失败之后记录第一个错误，之后的操作和检查点都不再生效，
+// 重新打开时撤销检查点之后的修改，就可以从数据文件中恢复
 type BPlusTree struct {
-	tree *bbolt.DB
+	tree       *bbolt.DB
+	syncWrites bool
+
+	errMu sync.Mutex
+	err   error
+}
+
+// Checkpoint 索引的检查点
+type Checkpoint struct {
+	Fid    uint32 // 检查点所在的文件 id
+	Offset int64  // 检查点在文件中的偏移
+	SeqNo  uint64 // 检查点时最新的事务序列号
+	Meta   []byte // 调用方附加的数据，和检查点一起原子地保存
 }
 
-// NewBPlusTree 初始化 B+ 树
-func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
+// NewBPlusTree 初始化 B+ 树，并撤销上一次检查点之后对索引的修改
+func NewBPlusTree(dirPath string, syncWrites bool) (*BPlusTree, error) {
 	opts := bbolt.DefaultOptions
 	opts.NoSync = !syncWrites
 	if err := os.MkdirAll(dirPath, os.ModePerm); err != nil {
-		panic("failed to create dir")
+		return nil, fmt.Errorf("failed to create dir: %w", err)
 	}
-	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, opts)
+	bptree, err := bbolt.Open(filepath.Join(dirPath, BPTreeIndexFileName), 0644, opts)
 	if err != nil {
-		panic("failed to open bptree")
+		return nil, fmt.Errorf("failed to open bptree: %w", err)
 	}
 	if err := bptree.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(indexBucketName)
+		for _, name := range [][]byte{indexBucketName, metaBucketName, undoBucketName} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		_ = bptree.Close()
+		return nil, fmt.Errorf("failed to create bucket in bptree: %w", err)
+	}
+	if err := bptree.Update(undoUncheckpointed); err != nil {
+		_ = bptree.Close()
+		return nil, fmt.Errorf("failed to recover bptree: %w", err)
+	}
+	return &BPlusTree{tree: bptree, syncWrites: syncWrites}, nil
+}
+
+// Err 返回第一次读写 bbolt 失败的错误
+func (bpt *BPlusTree) Err() error {
+	bpt.errMu.Lock()
+	defer bpt.errMu.Unlock()
+	return bpt.err
+}
+
+// fail 记录读写 bbolt 失败的错误，只保留第一个错误
+func (bpt *BPlusTree) fail(op string, err error) {
+	bpt.errMu.Lock()
+	defer bpt.errMu.Unlock()
+	if bpt.err == nil {
+		bpt.err = fmt.Errorf("failed to %s in bptree: %w", op, err)
+	}
+}
+
+// undoUncheckpointed 撤销检查点之后对索引的所有修改
+func undoUncheckpointed(tx *bbolt.Tx) error {
+	bucket := tx.Bucket(indexBucketName)
+	undo := tx.Bucket(undoBucketName)
+	if err := undo.ForEach(func(key, value []byte) error {
+		if len(value) > 0 && value[0] == undoPresent {
+			return bucket.Put(key, value[1:])
+		}
+		return bucket.Delete(key)
+	}); err != nil {
+		return err
+	}
+	return resetUndo(tx)
+}
+
+func resetUndo(tx *bbolt.Tx) error {
+	if err := tx.DeleteBucket(undoBucketName); err != nil {
+		return err
+	}
+	_, err := tx.CreateBucket(undoBucketName)
+	return err
+}
+
+// recordUndo 检查点之后第一次修改 key 时记录它原来的位置信息
+func recordUndo(tx *bbolt.Tx, key, oldVal []byte) error {
+	undo := tx.Bucket(undoBucketName)
+	if undo.Get(key) != nil {
+		return nil
+	}
+	if len(oldVal) == 0 {
+		return undo.Put(key, []byte{undoAbsent})
+	}
+	return undo.Put(key, append([]byte{undoPresent}, oldVal...))
+}
+
+// SaveCheckpoint 记录检查点，调用方需要保证检查点之前的数据已经持久化并更新到了索引中
+func (bpt *BPlusTree) SaveCheckpoint(cp *Checkpoint) error {
+	// 索引中可能缺少检查点之前的修改
+	if err := bpt.Err(); err != nil {
 		return err
+	}
+	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
+		return putCheckpoint(tx, cp)
 	}); err != nil {
-		panic("failed to create bucket in bptree")
+		return err
 	}
-	return &BPlusTree{tree: bptree}
+	return bpt.syncCheckpoint()
+}
+
+// ApplyWithCheckpoint 在一个 bbolt 事务中执行所有操作并记录检查点，不记录 undo，重新打开时不会被撤销
+// 用于必须和检查点一起持久化的修改，例如旧数据文件被删除之前将索引指向 merge 之后的文件
+func (bpt *BPlusTree) ApplyWithCheckpoint(ops []BatchOp, cp *Checkpoint) error {
+	if err := bpt.Err(); err != nil {
+		return err
+	}
+	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(indexBucketName)
+		for _, op := range ops {
+			var err error
+			if op.Delete {
+				err = bucket.Delete(op.Key)
+			} else {
+				err = bucket.Put(op.Key, data.EncodeLogRecordPos(op.Pos))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return putCheckpoint(tx, cp)
+	}); err != nil {
+		return err
+	}
+	return bpt.syncCheckpoint()
+}
+
+// putCheckpoint 在事务中写入检查点，检查点之前的修改不再需要撤销
+func putCheckpoint(tx *bbolt.Tx, cp *Checkpoint) error {
+	buf := make([]byte, 20+len(cp.Meta))
+	binary.BigEndian.PutUint32(buf[0:4], cp.Fid)
+	binary.BigEndian.PutUint64(buf[4:12], uint64(cp.Offset))
+	binary.BigEndian.PutUint64(buf[12:20], cp.SeqNo)
+	copy(buf[20:], cp.Meta)
+	if err := tx.Bucket(metaBucketName).Put(checkpointKey, buf); err != nil {
+		return err
+	}
+	return resetUndo(tx)
+}
+
+// syncCheckpoint 没有开启同步写入时，检查点需要手动持久化
+func (bpt *BPlusTree) syncCheckpoint() error {
+	if !bpt.syncWrites {
+		return bpt.tree.Sync()
+	}
+	return nil
+}
+
+// LoadCheckpoint 读取检查点，没有检查点时返回 nil
+func (bpt *BPlusTree) LoadCheckpoint() (*Checkpoint, error) {
+	var cp *Checkpoint
+	err := bpt.tree.View(func(tx *bbolt.Tx) error {
+		buf := tx.Bucket(metaBucketName).Get(checkpointKey)
+		if len(buf) < 20 {
+			return nil
+		}
+		cp = &Checkpoint{
+			Fid:    binary.BigEndian.Uint32(buf[0:4]),
+			Offset: int64(binary.BigEndian.Uint64(buf[4:12])),
+			SeqNo:  binary.BigEndian.Uint64(buf[12:20]),
+		}
+		// bbolt 返回的数据只在事务内有效
+		if len(buf) > 20 {
+			cp.Meta = append([]byte(nil), buf[20:]...)
+		}
+		return nil
+	})
+	return cp, err
 }
 
 // Put 向索引中存储 key 对应的位置信息
 func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	if bpt.Err() != nil {
+		return nil
+	}
 	var oldVal []byte
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
 		oldVal = bucket.Get(key)
-
+		if err := recordUndo(tx, key, oldVal); err != nil {
+			return err
+		}
 		return bucket.Put(key, data.EncodeLogRecordPos(pos))
 	}); err != nil {
-		panic("failed to put key-value in bptree")
+		bpt.fail("put key-value", err)
+		return nil
 	}
 	if len(oldVal) == 0 {
 		return nil
@@ -57,6 +245,9 @@ func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos
 
 // Get 根据 key 取出对应的位置信息
 func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
+	if bpt.Err() != nil {
+		return nil
+	}
 	var pos *data.LogRecordPos
 	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
@@ -67,22 +258,30 @@ func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
 		}
 		return nil
 	}); err != nil {
-		panic("failed to get key-value in bptree")
+		bpt.fail("get key-value", err)
+		return nil
 	}
 	return pos
 }
 
 // Delete 根据 key 删除对应的位置信息
 func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
+	if bpt.Err() != nil {
+		return nil, false
+	}
 	var oldVal []byte
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
 		if oldVal = bucket.Get(key); len(oldVal) != 0 {
+			if err := recordUndo(tx, key, oldVal); err != nil {
+				return err
+			}
 			return bucket.Delete(key)
 		}
 		return nil
 	}); err != nil {
-		panic("failed to delete key-value in bptree")
+		bpt.fail("delete key-value", err)
+		return nil, false
 	}
 	if len(oldVal) == 0 {
 		return nil, false
@@ -90,15 +289,56 @@ func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	return data.DecodeLogRecordPos(oldVal), true
 }
 
+// ApplyBatch 在一个 bbolt 事务中按顺序执行所有操作
+func (bpt *BPlusTree) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	if len(ops) == 0 || bpt.Err() != nil {
+		return oldPositions
+	}
+	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(indexBucketName)
+		for i, op := range ops {
+			oldVal := bucket.Get(op.Key)
+			if len(oldVal) == 0 && op.Delete {
+				continue
+			}
+			if err := recordUndo(tx, op.Key, oldVal); err != nil {
+				return err
+			}
+			if len(oldVal) != 0 {
+				oldPositions[i] = data.DecodeLogRecordPos(oldVal)
+			}
+			var err error
+			if op.Delete {
+				err = bucket.Delete(op.Key)
+			} else {
+				err = bucket.Put(op.Key, data.EncodeLogRecordPos(op.Pos))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		bpt.fail("apply batch", err)
+		return make([]*data.LogRecordPos, len(ops))
+	}
+	return oldPositions
+}
+
 // Size 索引中的数据量
 func (bpt *BPlusTree) Size() int {
+	if bpt.Err() != nil {
+		return 0
+	}
 	var size int
 	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
 		size = bucket.Stats().KeyN
 		return nil
 	}); err != nil {
-		panic("failed to get size in bptree")
+		bpt.fail("get size", err)
+		return 0
 	}
 	return size
 }
@@ -107,9 +347,17 @@ func (bpt *BPlusTree) Close() error {
 	return bpt.tree.Close()
 }
 
-// Iterator 初始化一个迭代器，用于遍历索引中的 key
+// Iterator 初始化一个迭代器，用于遍历索引中的 key，发生错误之后返回空的迭代器
 func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
-	return newBptreeIterator(bpt.tree, reverse)
+	if bpt.Err() != nil {
+		return &sliceIterator{reverse: reverse, cmp: bytes.Compare}
+	}
+	tx, err := bpt.tree.Begin(false)
+	if err != nil {
+		bpt.fail("begin a transaction", err)
+		return &sliceIterator{reverse: reverse, cmp: bytes.Compare}
+	}
+	return newBptreeIterator(tx, reverse)
 }
 
 // BPlusTreeIterator B+树索引迭代器
@@ -121,11 +369,7 @@ type bptreeIterator struct {
 	currValue []byte
 }
 
-func newBptreeIterator(tree *bbolt.DB, reverse bool) *bptreeIterator {
-	tx, err := tree.Begin(false)
-	if err != nil {
-		panic("failed to begin a transaction in bptree")
-	}
+func newBptreeIterator(tx *bbolt.Tx, reverse bool) *bptreeIterator {
 	bpi := &bptreeIterator{
 		tx:      tx,
 		cursor:  tx.Bucket(indexBucketName).Cursor(),
@@ -144,6 +388,15 @@ func (bpi *bptreeIterator) Rewind() {
 }
 func (bpi *bptreeIterator) Seek(key []byte) {
 	bpi.currKey, bpi.currValue = bpi.cursor.Seek(key)
+	if !bpi.reverse {
+		return
+	}
+	// 反向遍历时定位到小于等于 key 的最大的 key
+	if bpi.currKey == nil {
+		bpi.currKey, bpi.currValue = bpi.cursor.Last()
+	} else if bytes.Compare(bpi.currKey, key) > 0 {
+		bpi.currKey, bpi.currValue = bpi.cursor.Prev()
+	}
 }
 func (bpi *bptreeIterator) Next() {
 	if bpi.reverse {
@@ -156,7 +409,8 @@ func (bpi *bptreeIterator) Valid() bool {
 	return len(bpi.currKey) != 0
 }
 func (bpi *bptreeIterator) Key() []byte {
-	return bpi.currKey
+	// bbolt 返回的 key 只在事务内有效，拷贝一份避免迭代器关闭后被访问
+	return append([]byte(nil), bpi.currKey...)
 }
 func (bpi *bptreeIterator) Value() *data.LogRecordPos {
 	return data.DecodeLogRecordPos(bpi.currValue)
@@ -11,7 +11,8 @@ import (
 func TestBPlusTree_Put(t *testing.T) {
 	path := "./tmp"
 
-	tree := NewBPlusTree(path, false)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 	defer func() {
 		if err := tree.Close(); err != nil {
 			panic(err)
@@ -34,7 +35,8 @@ func TestBPlusTree_Put(t *testing.T) {
 func TestBPlusTree_Get(t *testing.T) {
 	path := "./tmp"
 
-	tree := NewBPlusTree(path, false)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 	defer func() {
 		if err := tree.Close(); err != nil {
 			panic(err)
@@ -59,7 +61,8 @@ func TestBPlusTree_Get(t *testing.T) {
 
 func TestBPlusTree_Delete(t *testing.T) {
 	path := "./tmp"
-	tree := NewBPlusTree(path, false)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 	defer func() {
 		if err := tree.Close(); err != nil {
 			panic(err)
@@ -85,7 +88,8 @@ func TestBPlusTree_Delete(t *testing.T) {
 func TestBPlusTree_Size(t *testing.T) {
 	path := "./tmp"
 
-	tree := NewBPlusTree(path, false)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 	defer func() {
 		if err := tree.Close(); err != nil {
 			panic(err)
@@ -104,7 +108,8 @@ func TestBPlusTree_Size(t *testing.T) {
 }
 func TestBPlusTree_Iterator(t *testing.T) {
 	path := "./tmp"
-	tree := NewBPlusTree(path, false)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 	defer func() {
 		if err := tree.Close(); err != nil {
 			panic(err)
@@ -125,5 +130,82 @@ func TestBPlusTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Key())
 		assert.NotNil(t, iter.Value())
 	}
+
+	// 反向遍历时定位到小于等于目标的最大的 key
+	iter.Seek([]byte("bbz"))
+	assert.Equal(t, []byte("bbca"), iter.Key())
+	iter.Seek([]byte("bbca"))
+	assert.Equal(t, []byte("bbca"), iter.Key())
+	iter.Seek([]byte("zz"))
+	assert.Equal(t, []byte("ccec"), iter.Key())
+	iter.Seek([]byte("a"))
+	assert.False(t, iter.Valid())
+	iter.Close()
+}
+
+func TestBPlusTree_ApplyBatch(t *testing.T) {
+	path := "./tmp"
+
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
+	defer func() {
+		if err := tree.Close(); err != nil {
+			panic(err)
+		}
+		if err := os.RemoveAll(path); err != nil {
+			panic(err)
+		}
+	}()
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 1, Offset: 10})
+
+	oldPositions := tree.ApplyBatch([]BatchOp{
+		{Key: []byte("aac"), Pos: &data.LogRecordPos{Fid: 2, Offset: 20}},
+		{Key: []byte("abc"), Pos: &data.LogRecordPos{Fid: 2, Offset: 30}},
+		{Key: []byte("abc"), Delete: true},
+		{Key: []byte("not-exist"), Delete: true},
+	})
+	assert.Equal(t, 4, len(oldPositions))
+	assert.Equal(t, int64(10), oldPositions[0].Offset)
+	assert.Nil(t, oldPositions[1])
+	assert.Equal(t, int64(30), oldPositions[2].Offset)
+	assert.Nil(t, oldPositions[3])
+
+	assert.Equal(t, int64(20), tree.Get([]byte("aac")).Offset)
+	assert.Nil(t, tree.Get([]byte("abc")))
+	assert.Equal(t, 1, tree.Size())
+}
+
+func TestBPlusTree_Err(t *testing.T) {
+	path := "./tmp"
+	defer os.RemoveAll(path)
+
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
+	tree.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	assert.Nil(t, tree.SaveCheckpoint(&Checkpoint{Fid: 1, Offset: 20}))
+	tree.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 20})
+	assert.Nil(t, tree.Err())
+
+	// 关闭 bbolt 模拟读写失败，之后的操作都不再生效
+	assert.Nil(t, tree.tree.Close())
+	assert.Nil(t, tree.Put([]byte("c"), &data.LogRecordPos{Fid: 1, Offset: 30}))
+	assert.NotNil(t, tree.Err())
+	assert.Nil(t, tree.Get([]byte("a")))
+	_, ok := tree.Delete([]byte("a"))
+	assert.False(t, ok)
+	assert.Equal(t, make([]*data.LogRecordPos, 1), tree.ApplyBatch([]BatchOp{{Key: []byte("d"), Delete: true}}))
+	assert.Equal(t, 0, tree.Size())
+	iter := tree.Iterator(false)
+	assert.False(t, iter.Valid())
 	iter.Close()
+	assert.Equal(t, tree.Err(), tree.SaveCheckpoint(&Checkpoint{Fid: 1, Offset: 30}))
+
+	// 重新打开时撤销检查点之后的修改
+	tree, err = NewBPlusTree(path, false)
+	assert.Nil(t, err)
+	defer tree.Close()
+	assert.Nil(t, tree.Err())
+	assert.Equal(t, int64(10), tree.Get([]byte("a")).Offset)
+	assert.Nil(t, tree.Get([]byte("b")))
+	assert.Nil(t, tree.Get([]byte("c")))
 }
@@ -3,118 +3,146 @@ package index
 import (
 	"bitcask-go/data"
 	"bytes"
-	"sort"
 	"sync"
+	"unsafe"
 
 	"github.com/google/btree"
 )
 
+// btreeItemOverhead 每个 key 除 key 本身以外占用的内存，包括 btree 节点中的指针、Item 和 LogRecordPos
+const btreeItemOverhead = int64(unsafe.Sizeof(&Item{}) + unsafe.Sizeof(Item{}) + unsafe.Sizeof(data.LogRecordPos{}))
+
 // BTree 索引，主要封装了 google 的 btree kv
 // https://github.com/google/btree
 
 type BTree struct {
-	tree *btree.BTree
-	lock *sync.RWMutex
+	tree     *btree.BTreeG[*Item]
+	lock     *sync.RWMutex
+	keyBytes int64 // 索引中 key 的总大小
 }
 
 // NewBTree 初始化 BTree 索引结构
 func NewBTree() *BTree {
+	return NewBTreeWithComparator(nil)
+}
+
+// NewBTreeWithComparator 初始化按照 cmp 排列 key 的 BTree 索引
+func NewBTreeWithComparator(cmp Comparator) *BTree {
+	cmp = defaultComparator(cmp)
 	return &BTree{
-		tree: btree.New(32),
+		tree: btree.NewG(32, func(a, b *Item) bool {
+			return cmp(a.key, b.key) < 0
+		}),
 		lock: new(sync.RWMutex),
 	}
 }
 func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	it := &Item{key: key, pos: pos}
 	bt.lock.Lock()
-	oldItem := bt.tree.ReplaceOrInsert(it)
+	oldItem, replaced := bt.tree.ReplaceOrInsert(it)
+	if !replaced {
+		bt.keyBytes += int64(len(key))
+	}
 	bt.lock.Unlock()
-	if oldItem == nil {
+	if !replaced {
 		return nil
 	}
-	return oldItem.(*Item).pos
+	return oldItem.pos
 }
 func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	it := &Item{key: key}
-	btreeItem := bt.tree.Get(it)
-	if btreeItem == nil {
+	bt.lock.RLock()
+	btreeItem, found := bt.tree.Get(it)
+	bt.lock.RUnlock()
+	if !found {
 		return nil
 	}
-	return btreeItem.(*Item).pos
+	return btreeItem.pos
 }
 func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	it := &Item{key: key}
 	bt.lock.Lock()
-	oldItem := bt.tree.Delete(it)
+	oldItem, deleted := bt.tree.Delete(it)
+	if deleted {
+		bt.keyBytes -= int64(len(key))
+	}
 	bt.lock.Unlock()
-	if oldItem == nil {
+	if !deleted {
 		return nil, false
 	}
-	return oldItem.(*Item).pos, true
+	return oldItem.pos, true
+}
+
+// ApplyBatch 按顺序批量更新索引
+func (bt *BTree) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	return applyBatch(bt, ops)
 }
 
 func (bt *BTree) Size() int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	return bt.tree.Len()
 }
+
+// MemSize 索引占用内存的估计值，每个 key 需要一个索引项和一个位置信息
+func (bt *BTree) MemSize() int64 {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
+	return int64(bt.tree.Len())*btreeItemOverhead + bt.keyBytes
+}
+
 func (bt *BTree) Close() error {
 	return nil
 }
+
+// Iterator 初始化一个迭代器，用于遍历索引中的 key
+// 迭代器持有树的写时复制快照，克隆的开销与数据量无关，之后的写入也不会影响迭代器
 func (bt *BTree) Iterator(reverse bool) Iterator {
 	if bt.tree == nil {
 		return nil
 	}
-	bt.lock.RLock()
-	defer bt.lock.RUnlock()
-	return newBTreeIterator(bt.tree, reverse)
+	// Clone 会修改原来的树，不能和读取并发执行
+	bt.lock.Lock()
+	snapshot := bt.tree.Clone()
+	bt.lock.Unlock()
+	return newBTreeIterator(snapshot, reverse)
 }
 
+// btree 迭代器每次从快照中取出的数据量
+const btreeIteratorBatchSize = 64
+
+// btreeIterator BTree 索引迭代器，按批次从快照中读取数据，不需要在创建时拷贝所有数据
 type btreeIterator struct {
-	currIndex int     // 当前遍历的下标位置
-	reverse   bool    // 是否是反向遍历
-	values    []*Item // key+位置索引信息
+	tree      *btree.BTreeG[*Item] // 创建迭代器时的快照
+	currIndex int                  // 当前遍历的下标位置
+	reverse   bool                 // 是否是反向遍历
+	values    []*Item              // 当前批次的 key+位置索引信息
+	exhausted bool                 // 当前批次之后是否还有数据
 }
 
-func newBTreeIterator(tree *btree.BTree, reverse bool) *btreeIterator {
-	var idx int
-	values := make([]*Item, tree.Len())
-
-	// 将所有的数据存放到数组中
-	saveValues := func(it btree.Item) bool {
-		values[idx] = it.(*Item)
-		idx++
-		return true
-	}
-
-	if reverse {
-		tree.Descend(saveValues)
-	} else {
-		tree.Ascend(saveValues)
-	}
-	return &btreeIterator{
-		currIndex: 0,
-		reverse:   reverse,
-		values:    values,
+func newBTreeIterator(tree *btree.BTreeG[*Item], reverse bool) *btreeIterator {
+	bti := &btreeIterator{
+		tree:    tree,
+		reverse: reverse,
 	}
+	bti.Rewind()
+	return bti
 }
 
 func (bti *btreeIterator) Rewind() {
-	bti.currIndex = 0
+	bti.fill(nil, false)
 }
 
 func (bti *btreeIterator) Seek(key []byte) {
-	if bti.reverse {
-		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) <= 0
-		})
-	} else {
-		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) >= 0
-		})
-	}
+	bti.fill(key, false)
 }
 
 func (bti *btreeIterator) Next() {
 	bti.currIndex += 1
+	if bti.currIndex >= len(bti.values) && !bti.exhausted {
+		// 从当前批次的最后一个 key 之后继续读取
+		bti.fill(bti.values[len(bti.values)-1].key, true)
+	}
 }
 
 func (bti *btreeIterator) Valid() bool {
@@ -130,5 +158,36 @@ func (bti *btreeIterator) Value() *data.LogRecordPos {
 }
 
 func (bti *btreeIterator) Close() {
+	bti.tree = nil
 	bti.values = nil
 }
+
+// fill 从 pivot 开始(为空时从头开始)读取下一批数据，skipPivot 表示不包括 pivot 本身
+func (bti *btreeIterator) fill(pivot []byte, skipPivot bool) {
+	bti.currIndex = 0
+	bti.values = make([]*Item, 0, btreeIteratorBatchSize)
+	if bti.tree == nil {
+		bti.exhausted = true
+		return
+	}
+
+	saveValues := func(item *Item) bool {
+		if skipPivot && bytes.Equal(item.key, pivot) {
+			return true
+		}
+		bti.values = append(bti.values, item)
+		return len(bti.values) < btreeIteratorBatchSize
+	}
+
+	switch {
+	case pivot == nil && bti.reverse:
+		bti.tree.Descend(saveValues)
+	case pivot == nil:
+		bti.tree.Ascend(saveValues)
+	case bti.reverse:
+		bti.tree.DescendLessOrEqual(&Item{key: pivot}, saveValues)
+	default:
+		bti.tree.AscendGreaterOrEqual(&Item{key: pivot}, saveValues)
+	}
+	bti.exhausted = len(bti.values) < btreeIteratorBatchSize
+}
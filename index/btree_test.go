@@ -2,6 +2,8 @@ package index
 
 import (
 	"bitcask-go/data"
+	"bytes"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -107,3 +109,81 @@ func TestBTree_Iterator(t *testing.T) {
 	}
 
 }
+
+func TestBTree_IteratorSnapshot(t *testing.T) {
+	bt := NewBTree()
+	// 数据量超过一个批次
+	for i := 0; i < 200; i++ {
+		bt.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	iter := bt.Iterator(false)
+
+	// 创建迭代器之后的写入对迭代器不可见
+	bt.Put([]byte("key-000"), &data.LogRecordPos{Fid: 2, Offset: 0})
+	bt.Put([]byte("key-500"), &data.LogRecordPos{Fid: 2, Offset: 500})
+	bt.Delete([]byte("key-100"))
+
+	var i int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter.Key())
+		assert.Equal(t, uint32(1), iter.Value().Fid)
+		i++
+	}
+	assert.Equal(t, 200, i)
+
+	// 反向遍历跨越多个批次
+	iter2 := bt.Iterator(true)
+	var n int
+	for iter2.Seek([]byte("key-150")); iter2.Valid(); iter2.Next() {
+		n++
+	}
+	assert.Equal(t, 150, n)
+	iter2.Seek([]byte("key-100"))
+	assert.Equal(t, []byte("key-099"), iter2.Key())
+	iter2.Close()
+}
+
+func TestBTree_ApplyBatch(t *testing.T) {
+	bt := NewBTree()
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
+
+	oldPositions := bt.ApplyBatch([]BatchOp{
+		{Key: []byte("a"), Pos: &data.LogRecordPos{Fid: 2, Offset: 20}},
+		{Key: []byte("b"), Pos: &data.LogRecordPos{Fid: 2, Offset: 30}},
+		{Key: []byte("a"), Delete: true},
+	})
+	assert.Equal(t, int64(10), oldPositions[0].Offset)
+	assert.Nil(t, oldPositions[1])
+	assert.Equal(t, int64(20), oldPositions[2].Offset)
+
+	assert.Nil(t, bt.Get([]byte("a")))
+	assert.Equal(t, int64(30), bt.Get([]byte("b")).Offset)
+	assert.Equal(t, 1, bt.Size())
+}
+
+func TestBTree_Comparator(t *testing.T) {
+	// 忽略大小写排列，大小写不同的 key 仍然是不同的 key
+	caseInsensitive := func(a, b []byte) int {
+		if cmp := bytes.Compare(bytes.ToLower(a), bytes.ToLower(b)); cmp != 0 {
+			return cmp
+		}
+		return bytes.Compare(a, b)
+	}
+	bt := NewBTreeWithComparator(caseInsensitive)
+	for _, key := range []string{"b", "C", "a", "B", "c"} {
+		bt.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 10})
+	}
+	assert.Equal(t, 5, bt.Size())
+	assert.NotNil(t, bt.Get([]byte("C")))
+
+	var keys []string
+	iter := bt.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.Equal(t, []string{"a", "B", "b", "C", "c"}, keys)
+
+	iter.Seek([]byte("bb"))
+	assert.Equal(t, []byte("C"), iter.Key())
+	iter.Close()
+}
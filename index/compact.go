@@ -0,0 +1,379 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"hash/maphash"
+	"sync"
+	"unsafe"
+
+	"github.com/google/btree"
+)
+
+const (
+	// 紧凑索引默认的分片数量
+	compactShardCount = 16
+
+	// key 存储区每个块的大小
+	compactArenaChunkSize = 1 << 20
+
+	// 查找时临时 key 使用的块编号，不对应存储区中真实的块
+	compactProbeChunk = ^uint32(0)
+)
+
+// CompactIndex 内存紧凑的有序索引
+// key 统一拷贝到按块分配的存储区中，位置信息直接保存在定长的索引项内，
+// 每个索引项不再持有指针，大量小 key 时可以显著减少内存占用和 GC 扫描的开销
+type CompactIndex struct {
+	seed   maphash.Seed
+	shards []*compactShard
+	cmp    Comparator
+}
+
+// compactEntry 索引项，key 在存储区中的位置和数据的位置信息
+type compactEntry struct {
+	offset    int64  // 数据在文件中的偏移
+	timestamp int64  // 数据的写入时间
+	fid       uint32 // 数据所在的文件 id
+	size      uint32 // 数据在磁盘上的大小
+	valueSize uint32 // value 的长度
+	chunk     uint32 // key 所在存储区块的编号
+	keyOff    uint32 // key 在块中的偏移
+	keyLen    uint32 // key 的长度
+}
+
+func (e compactEntry) pos() *data.LogRecordPos {
+	return &data.LogRecordPos{
+		Fid:       e.fid,
+		Offset:    e.offset,
+		Size:      e.size,
+		ValueSize: e.valueSize,
+		Timestamp: e.timestamp,
+	}
+}
+
+// compactShard 紧凑索引的一个分片
+// 比较 key 时需要借助存储区和查找用的临时 key，因此读写都持有同一把锁
+type compactShard struct {
+	lock      sync.Mutex
+	tree      *btree.BTreeG[compactEntry]
+	arena     *compactArena // tree 中的索引项使用的存储区
+	cmp       Comparator
+	keyBytes  int64 // 索引中有效 key 的总大小
+	arenaSize int64 // 存储区已经分配的总大小
+}
+
+// compactArena key 存储区，已写入的内容不会再被修改
+// 整理存储区时会创建新的存储区和新的树，迭代器快照中的树仍然使用原来的存储区
+type compactArena struct {
+	chunks [][]byte
+	probe  []byte // 查找时临时使用的 key，只在持有分片的锁时设置
+}
+
+func (a *compactArena) key(e compactEntry) []byte {
+	if e.chunk == compactProbeChunk {
+		return a.probe
+	}
+	return a.chunks[e.chunk][e.keyOff : e.keyOff+e.keyLen : e.keyOff+e.keyLen]
+}
+
+// NewCompactIndex 初始化紧凑索引，cmp 为空时按照字节序排列 key
+func NewCompactIndex(shardCount int, cmp Comparator) *CompactIndex {
+	cmp = defaultComparator(cmp)
+	shards := make([]*compactShard, shardCount)
+	for i := range shards {
+		shards[i] = newCompactShard(cmp)
+	}
+	return &CompactIndex{
+		seed:   maphash.MakeSeed(),
+		shards: shards,
+		cmp:    cmp,
+	}
+}
+
+// Put 向索引中存储 key 对应的位置信息
+func (ci *CompactIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	return ci.getShard(key).put(key, pos)
+}
+
+// Get 根据 key 取出对应的位置信息
+func (ci *CompactIndex) Get(key []byte) *data.LogRecordPos {
+	return ci.getShard(key).get(key)
+}
+
+// Delete 根据 key 删除对应的位置信息
+func (ci *CompactIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	return ci.getShard(key).delete(key)
+}
+
+// ApplyBatch 按顺序批量更新索引
+func (ci *CompactIndex) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	return applyBatch(ci, ops)
+}
+
+// Size 索引中的数据量
+func (ci *CompactIndex) Size() int {
+	var size int
+	for _, shard := range ci.shards {
+		shard.lock.Lock()
+		size += shard.tree.Len()
+		shard.lock.Unlock()
+	}
+	return size
+}
+
+// MemSize 索引占用的内存大小，包括索引项和 key 存储区
+func (ci *CompactIndex) MemSize() int64 {
+	var size int64
+	for _, shard := range ci.shards {
+		shard.lock.Lock()
+		size += int64(shard.tree.Len())*int64(unsafe.Sizeof(compactEntry{})) + shard.arenaSize
+		shard.lock.Unlock()
+	}
+	return size
+}
+
+func (ci *CompactIndex) Close() error {
+	return nil
+}
+
+// Iterator 初始化一个迭代器，用于遍历索引中的 key
+// 每个分片持有树的写时复制快照，按批次读取，不会在创建时拷贝所有的索引项
+func (ci *CompactIndex) Iterator(reverse bool) Iterator {
+	iters := make([]Iterator, len(ci.shards))
+	for i, shard := range ci.shards {
+		iters[i] = shard.iterator(reverse)
+	}
+	return newMergingIterator(iters, reverse, ci.cmp)
+}
+
+func (ci *CompactIndex) getShard(key []byte) *compactShard {
+	return ci.shards[maphash.Bytes(ci.seed, key)%uint64(len(ci.shards))]
+}
+
+func newCompactShard(cmp Comparator) *compactShard {
+	cs := &compactShard{cmp: cmp}
+	cs.resetTree()
+	return cs
+}
+
+// resetTree 创建新的存储区和使用它比较 key 的空树
+func (cs *compactShard) resetTree() {
+	arena := &compactArena{}
+	cs.arena = arena
+	cs.arenaSize = 0
+	cs.tree = btree.NewG(32, func(a, b compactEntry) bool {
+		return cs.cmp(arena.key(a), arena.key(b)) < 0
+	})
+}
+
+func (cs *compactShard) put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	entry := compactEntry{
+		offset:    pos.Offset,
+		timestamp: pos.Timestamp,
+		fid:       pos.Fid,
+		size:      pos.Size,
+		valueSize: pos.ValueSize,
+	}
+	old, ok := cs.tree.Get(cs.probeEntry(key))
+	cs.arena.probe = nil
+	if ok {
+		// key 已经存在，复用存储区中的 key
+		entry.chunk, entry.keyOff, entry.keyLen = old.chunk, old.keyOff, old.keyLen
+		cs.tree.ReplaceOrInsert(entry)
+		return old.pos()
+	}
+	entry.chunk, entry.keyOff = cs.alloc(key)
+	entry.keyLen = uint32(len(key))
+	cs.tree.ReplaceOrInsert(entry)
+	cs.keyBytes += int64(len(key))
+	return nil
+}
+
+func (cs *compactShard) get(key []byte) *data.LogRecordPos {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	entry, ok := cs.tree.Get(cs.probeEntry(key))
+	cs.arena.probe = nil
+	if !ok {
+		return nil
+	}
+	return entry.pos()
+}
+
+func (cs *compactShard) delete(key []byte) (*data.LogRecordPos, bool) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	entry, ok := cs.tree.Delete(cs.probeEntry(key))
+	cs.arena.probe = nil
+	if !ok {
+		return nil, false
+	}
+	cs.keyBytes -= int64(entry.keyLen)
+	cs.maybeCompact()
+	return entry.pos(), true
+}
+
+// probeEntry 构造查找 key 使用的临时索引项，需要持有锁
+// 查找结束后需要清空存储区中的临时 key，避免一直引用调用方的 key
+func (cs *compactShard) probeEntry(key []byte) compactEntry {
+	cs.arena.probe = key
+	return compactEntry{chunk: compactProbeChunk}
+}
+
+// alloc 将 key 拷贝到存储区中，返回所在的块编号和块内偏移
+func (cs *compactShard) alloc(key []byte) (uint32, uint32) {
+	chunks := cs.arena.chunks
+	n := len(chunks)
+	if n == 0 || len(chunks[n-1])+len(key) > cap(chunks[n-1]) {
+		// 超过块大小的 key 单独占用一个块
+		chunkSize := compactArenaChunkSize
+		if len(key) > chunkSize {
+			chunkSize = len(key)
+		}
+		chunks = append(chunks, make([]byte, 0, chunkSize))
+		cs.arenaSize += int64(chunkSize)
+		n++
+	}
+	chunk := chunks[n-1]
+	off := len(chunk)
+	chunks[n-1] = append(chunk, key...)
+	cs.arena.chunks = chunks
+	return uint32(n - 1), uint32(off)
+}
+
+// maybeCompact 被删除的 key 占用的空间超过存储区的一半时，重新整理存储区
+func (cs *compactShard) maybeCompact() {
+	if cs.arenaSize <= compactArenaChunkSize || cs.keyBytes*2 >= cs.arenaSize {
+		return
+	}
+	entries := make([]compactEntry, 0, cs.tree.Len())
+	cs.tree.Ascend(func(e compactEntry) bool {
+		entries = append(entries, e)
+		return true
+	})
+
+	// 迭代器的快照仍然引用原来的存储区，不能在原地整理
+	oldArena := cs.arena
+	cs.resetTree()
+	for i, e := range entries {
+		entries[i].chunk, entries[i].keyOff = cs.alloc(oldArena.key(e))
+	}
+	for _, e := range entries {
+		cs.tree.ReplaceOrInsert(e)
+	}
+}
+
+// iterator 创建分片的写时复制快照，克隆的开销与数据量无关
+func (cs *compactShard) iterator(reverse bool) *compactIterator {
+	// Clone 会修改原来的树，需要持有锁
+	cs.lock.Lock()
+	snapshot, arena := cs.tree.Clone(), cs.arena
+	cs.lock.Unlock()
+	cit := &compactIterator{
+		shard:   cs,
+		tree:    snapshot,
+		arena:   arena,
+		reverse: reverse,
+	}
+	cit.Rewind()
+	return cit
+}
+
+// compact 迭代器每次从快照中取出的数据量
+const compactIteratorBatchSize = 64
+
+// compactItem 迭代器批次中的一项，key 在持有锁时从存储区中取出
+type compactItem struct {
+	key   []byte
+	entry compactEntry
+}
+
+// compactIterator 紧凑索引单个分片的迭代器，按批次从快照中读取数据
+// 比较 key 需要使用存储区和临时 key，读取每个批次时持有分片的锁
+type compactIterator struct {
+	shard     *compactShard
+	tree      *btree.BTreeG[compactEntry] // 创建迭代器时的快照
+	arena     *compactArena               // 快照中的索引项使用的存储区
+	currIndex int                         // 当前遍历的下标位置
+	reverse   bool                        // 是否是反向遍历
+	values    []compactItem               // 当前批次的数据
+	exhausted bool                        // 当前批次之后是否还有数据
+}
+
+func (cit *compactIterator) Rewind() {
+	cit.fill(nil, false)
+}
+
+func (cit *compactIterator) Seek(key []byte) {
+	cit.fill(key, false)
+}
+
+func (cit *compactIterator) Next() {
+	cit.currIndex += 1
+	if cit.currIndex >= len(cit.values) && !cit.exhausted {
+		// 从当前批次的最后一个 key 之后继续读取
+		last := cit.values[len(cit.values)-1]
+		cit.fill(last.key, true)
+	}
+}
+
+func (cit *compactIterator) Valid() bool {
+	return cit.currIndex < len(cit.values)
+}
+
+func (cit *compactIterator) Key() []byte {
+	return cit.values[cit.currIndex].key
+}
+
+func (cit *compactIterator) Value() *data.LogRecordPos {
+	return cit.values[cit.currIndex].entry.pos()
+}
+
+func (cit *compactIterator) Close() {
+	cit.tree = nil
+	cit.arena = nil
+	cit.values = nil
+}
+
+// fill 从 pivot 开始(为空时从头开始)读取下一批数据，skipPivot 表示不包括 pivot 本身
+func (cit *compactIterator) fill(pivot []byte, skipPivot bool) {
+	cit.currIndex = 0
+	cit.values = make([]compactItem, 0, compactIteratorBatchSize)
+	if cit.tree == nil {
+		cit.exhausted = true
+		return
+	}
+
+	cit.shard.lock.Lock()
+	defer cit.shard.lock.Unlock()
+	saveValues := func(e compactEntry) bool {
+		key := cit.arena.key(e)
+		if skipPivot && bytes.Equal(key, pivot) {
+			return true
+		}
+		cit.values = append(cit.values, compactItem{key: key, entry: e})
+		return len(cit.values) < compactIteratorBatchSize
+	}
+
+	switch {
+	case pivot == nil && cit.reverse:
+		cit.tree.Descend(saveValues)
+	case pivot == nil:
+		cit.tree.Ascend(saveValues)
+	default:
+		// 快照的树使用自己的存储区比较 key，临时 key 也设置在这个存储区中
+		cit.arena.probe = pivot
+		probe := compactEntry{chunk: compactProbeChunk}
+		if cit.reverse {
+			cit.tree.DescendLessOrEqual(probe, saveValues)
+		} else {
+			cit.tree.AscendGreaterOrEqual(probe, saveValues)
+		}
+		cit.arena.probe = nil
+	}
+	cit.exhausted = len(cit.values) < compactIteratorBatchSize
+}
@@ -0,0 +1,149 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactIndex_Put(t *testing.T) {
+	ci := NewCompactIndex(4, nil)
+	res1 := ci.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12, Size: 5})
+	assert.Nil(t, res1)
+	res2 := ci.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	assert.Nil(t, res2)
+
+	res3 := ci.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1123, Offset: 990})
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 12, Size: 5}, res3)
+	assert.Equal(t, 2, ci.Size())
+}
+
+func TestCompactIndex_Get(t *testing.T) {
+	ci := NewCompactIndex(4, nil)
+	key := []byte("key-1")
+	ci.Put(key, &data.LogRecordPos{Fid: 1, Offset: 12, Size: 5})
+	// 索引中保存的是 key 的拷贝
+	key[0] = 'x'
+	pos := ci.Get([]byte("key-1"))
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 12, Size: 5}, pos)
+
+	pos1 := ci.Get([]byte("not exist"))
+	assert.Nil(t, pos1)
+}
+
+func TestCompactIndex_Delete(t *testing.T) {
+	ci := NewCompactIndex(4, nil)
+	res1, ok1 := ci.Delete([]byte("not exist"))
+	assert.Nil(t, res1)
+	assert.False(t, ok1)
+
+	ci.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	res2, ok2 := ci.Delete([]byte("key-1"))
+	assert.True(t, ok2)
+	assert.Equal(t, int64(12), res2.Offset)
+	assert.Nil(t, ci.Get([]byte("key-1")))
+	assert.Equal(t, 0, ci.Size())
+}
+
+func TestCompactIndex_Compact(t *testing.T) {
+	ci := NewCompactIndex(1, nil)
+	value := make([]byte, 1024)
+	for i := 0; i < 4096; i++ {
+		key := append([]byte(fmt.Sprintf("key-%04d-", i)), value...)
+		ci.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	memSize := ci.MemSize()
+
+	// 删除大部分 key 之后整理存储区
+	for i := 0; i < 4000; i++ {
+		key := append([]byte(fmt.Sprintf("key-%04d-", i)), value...)
+		_, ok := ci.Delete(key)
+		assert.True(t, ok)
+	}
+	assert.Less(t, ci.MemSize(), memSize/4)
+	assert.Equal(t, 96, ci.Size())
+	for i := 4000; i < 4096; i++ {
+		key := append([]byte(fmt.Sprintf("key-%04d-", i)), value...)
+		pos := ci.Get(key)
+		assert.NotNil(t, pos)
+		assert.Equal(t, int64(i), pos.Offset)
+	}
+}
+
+func TestCompactIndex_Iterator(t *testing.T) {
+	ci := NewCompactIndex(4, nil)
+	// 1.索引为空的情况
+	iter1 := ci.Iterator(false)
+	assert.False(t, iter1.Valid())
+
+	// 2.有多条数据，按照 key 的顺序遍历
+	for i := 99; i >= 0; i-- {
+		ci.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	iter2 := ci.Iterator(false)
+	// 创建迭代器之后的写入不影响遍历
+	ci.Put([]byte("key-100"), &data.LogRecordPos{Fid: 1, Offset: 100})
+	var i int
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter2.Key())
+		assert.Equal(t, int64(i), iter2.Value().Offset)
+		i++
+	}
+	assert.Equal(t, 100, i)
+
+	// 3.反向遍历
+	iter3 := ci.Iterator(true)
+	i = 100
+	for iter3.Rewind(); iter3.Valid(); iter3.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter3.Key())
+		i--
+	}
+	assert.Equal(t, -1, i)
+
+	// 4.seek
+	iter4 := ci.Iterator(false)
+	iter4.Seek([]byte("key-050"))
+	assert.Equal(t, []byte("key-050"), iter4.Key())
+	iter5 := ci.Iterator(true)
+	iter5.Seek([]byte("key-050a"))
+	assert.Equal(t, []byte("key-050"), iter5.Key())
+}
+
+func TestCompactIndex_IteratorSnapshot(t *testing.T) {
+	ci := NewCompactIndex(1, nil)
+	value := make([]byte, 1024)
+	getKey := func(i int) []byte {
+		return append([]byte(fmt.Sprintf("key-%04d-", i)), value...)
+	}
+	for i := 0; i < 2048; i++ {
+		ci.Put(getKey(i), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	// 查找之后不再引用调用方的 key
+	assert.NotNil(t, ci.Get(getKey(0)))
+	assert.Nil(t, ci.shards[0].arena.probe)
+
+	iter := ci.Iterator(false)
+	defer iter.Close()
+	// 删除大部分 key 触发存储区整理，快照仍然使用原来的存储区
+	arena := ci.shards[0].arena
+	for i := 0; i < 2000; i++ {
+		_, ok := ci.Delete(getKey(i))
+		assert.True(t, ok)
+	}
+	assert.NotSame(t, arena, ci.shards[0].arena)
+	ci.Put([]byte("key-new"), &data.LogRecordPos{Fid: 2})
+
+	i := 0
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, getKey(i), iter.Key())
+		assert.Equal(t, int64(i), iter.Value().Offset)
+		i++
+	}
+	assert.Equal(t, 2048, i)
+
+	iter.Seek(getKey(1000))
+	assert.Equal(t, getKey(1000), iter.Key())
+	assert.Nil(t, arena.probe)
+}
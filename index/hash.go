@@ -0,0 +1,150 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"hash/maphash"
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+// 哈希索引的分片数量
+const hashShardCount = 64
+
+// HashIndex 哈希索引，按照 key 的哈希值分片，每个分片单独加锁
+// 只适合点查询，迭代时需要将所有 key 取出并排序
+type HashIndex struct {
+	seed   maphash.Seed
+	shards []*hashShard
+	cmp    Comparator // 迭代时 key 的排列顺序
+}
+
+type hashShard struct {
+	items    map[string]*data.LogRecordPos
+	lock     *sync.RWMutex
+	keyBytes int64 // 分片中 key 的总大小
+}
+
+// hashItemOverhead 每个 key 除 key 本身以外占用的内存，包括 map 中的 key、value 和 LogRecordPos
+const hashItemOverhead = int64(unsafe.Sizeof("") + unsafe.Sizeof(&data.LogRecordPos{}) + unsafe.Sizeof(data.LogRecordPos{}))
+
+// NewHashIndex 初始化哈希索引
+func NewHashIndex() *HashIndex {
+	return NewHashIndexWithComparator(nil)
+}
+
+// NewHashIndexWithComparator 初始化哈希索引，迭代时按照 cmp 排列 key
+func NewHashIndexWithComparator(cmp Comparator) *HashIndex {
+	shards := make([]*hashShard, hashShardCount)
+	for i := range shards {
+		shards[i] = &hashShard{
+			items: make(map[string]*data.LogRecordPos),
+			lock:  new(sync.RWMutex),
+		}
+	}
+	return &HashIndex{
+		seed:   maphash.MakeSeed(),
+		shards: shards,
+		cmp:    defaultComparator(cmp),
+	}
+}
+
+// Put 向索引中存储 key 对应的位置信息
+func (hi *HashIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	shard := hi.getShard(key)
+	shard.lock.Lock()
+	oldPos, ok := shard.items[string(key)]
+	if !ok {
+		shard.keyBytes += int64(len(key))
+	}
+	shard.items[string(key)] = pos
+	shard.lock.Unlock()
+	return oldPos
+}
+
+// Get 根据 key 取出对应的位置信息
+func (hi *HashIndex) Get(key []byte) *data.LogRecordPos {
+	shard := hi.getShard(key)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+	return shard.items[string(key)]
+}
+
+// Delete 根据 key 删除对应的位置信息
+func (hi *HashIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	shard := hi.getShard(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	oldPos, ok := shard.items[string(key)]
+	if !ok {
+		return nil, false
+	}
+	delete(shard.items, string(key))
+	shard.keyBytes -= int64(len(key))
+	return oldPos, true
+}
+
+// ApplyBatch 按顺序批量更新索引
+func (hi *HashIndex) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	return applyBatch(hi, ops)
+}
+
+// Size 索引中的数据量
+func (hi *HashIndex) Size() int {
+	var size int
+	for _, shard := range hi.shards {
+		shard.lock.RLock()
+		size += len(shard.items)
+		shard.lock.RUnlock()
+	}
+	return size
+}
+
+// MemSize 索引占用内存的估计值，不包括 map 自身的桶
+func (hi *HashIndex) MemSize() int64 {
+	var size int64
+	for _, shard := range hi.shards {
+		shard.lock.RLock()
+		size += int64(len(shard.items))*hashItemOverhead + shard.keyBytes
+		shard.lock.RUnlock()
+	}
+	return size
+}
+
+func (hi *HashIndex) Close() error {
+	return nil
+}
+
+// Iterator 初始化一个迭代器，用于遍历索引中的 key
+func (hi *HashIndex) Iterator(reverse bool) Iterator {
+	return newHashIterator(hi.shards, reverse, hi.cmp)
+}
+
+func (hi *HashIndex) getShard(key []byte) *hashShard {
+	return hi.shards[maphash.Bytes(hi.seed, key)%hashShardCount]
+}
+
+// newHashIterator 哈希索引迭代器，创建时将所有 key 取出并排序
+func newHashIterator(shards []*hashShard, reverse bool, cmp Comparator) *sliceIterator {
+	var values []*Item
+	for _, shard := range shards {
+		shard.lock.RLock()
+		for key, pos := range shard.items {
+			values = append(values, &Item{key: []byte(key), pos: pos})
+		}
+		shard.lock.RUnlock()
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		if reverse {
+			return cmp(values[i].key, values[j].key) > 0
+		}
+		return cmp(values[i].key, values[j].key) < 0
+	})
+	return &sliceIterator{
+		currIndex: 0,
+		reverse:   reverse,
+		values:    values,
+		cmp:       cmp,
+	}
+}
@@ -0,0 +1,93 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashIndex_Put(t *testing.T) {
+	hi := NewHashIndex()
+	res1 := hi.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	assert.Nil(t, res1)
+	res2 := hi.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	assert.Nil(t, res2)
+
+	res3 := hi.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1123, Offset: 990})
+	assert.Equal(t, uint32(1), res3.Fid)
+	assert.Equal(t, int64(12), res3.Offset)
+	assert.Equal(t, 2, hi.Size())
+}
+
+func TestHashIndex_Get(t *testing.T) {
+	hi := NewHashIndex()
+	hi.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	pos := hi.Get([]byte("key-1"))
+	assert.NotNil(t, pos)
+	assert.Equal(t, uint32(1), pos.Fid)
+	assert.Equal(t, int64(12), pos.Offset)
+
+	pos1 := hi.Get([]byte("not exist"))
+	assert.Nil(t, pos1)
+
+	hi.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1123, Offset: 990})
+	pos2 := hi.Get([]byte("key-1"))
+	assert.Equal(t, uint32(1123), pos2.Fid)
+	assert.Equal(t, int64(990), pos2.Offset)
+}
+
+func TestHashIndex_Delete(t *testing.T) {
+	hi := NewHashIndex()
+	res1, ok1 := hi.Delete([]byte("not exist"))
+	assert.Nil(t, res1)
+	assert.False(t, ok1)
+
+	hi.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	res2, ok2 := hi.Delete([]byte("key-1"))
+	assert.True(t, ok2)
+	assert.Equal(t, uint32(1), res2.Fid)
+	assert.Equal(t, int64(12), res2.Offset)
+
+	pos := hi.Get([]byte("key-1"))
+	assert.Nil(t, pos)
+	assert.Equal(t, 0, hi.Size())
+}
+
+func TestHashIndex_Iterator(t *testing.T) {
+	hi := NewHashIndex()
+	// 1.索引为空的情况
+	iter1 := hi.Iterator(false)
+	assert.False(t, iter1.Valid())
+
+	// 2.有多条数据，按照 key 的顺序遍历
+	for i := 99; i >= 0; i-- {
+		hi.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	iter2 := hi.Iterator(false)
+	var i int
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter2.Key())
+		assert.Equal(t, int64(i), iter2.Value().Offset)
+		i++
+	}
+	assert.Equal(t, 100, i)
+
+	// 3.反向遍历
+	iter3 := hi.Iterator(true)
+	i = 99
+	for iter3.Rewind(); iter3.Valid(); iter3.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter3.Key())
+		i--
+	}
+	assert.Equal(t, -1, i)
+
+	// 4.seek
+	iter4 := hi.Iterator(false)
+	iter4.Seek([]byte("key-050"))
+	assert.Equal(t, []byte("key-050"), iter4.Key())
+	iter5 := hi.Iterator(true)
+	iter5.Seek([]byte("key-050a"))
+	assert.Equal(t, []byte("key-050"), iter5.Key())
+}
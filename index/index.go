@@ -3,8 +3,8 @@ package index
 import (
 	"bitcask-go/data"
 	"bytes"
-
-	"github.com/google/btree"
+	"errors"
+	"sort"
 )
 
 // Indexer 抽象索引接口，如需加入其他数据结构可在这直接实现
@@ -15,6 +15,8 @@ type Indexer interface {
 	Get(key []byte) *data.LogRecordPos
 	// Delete 根据 key 删除对应的位置信息
 	Delete(key []byte) (*data.LogRecordPos, bool)
+	// ApplyBatch 按顺序批量更新索引，返回每个操作之前 key 对应的位置信息
+	ApplyBatch(ops []BatchOp) []*data.LogRecordPos
 	// Iterator 初始化一个迭代器，用于遍历索引中的 key
 	Iterator(reverse bool) Iterator
 	// Size 索引中的数据量
@@ -24,6 +26,38 @@ type Indexer interface {
 	Close() error
 }
 
+// BatchOp 批量更新索引的一个操作
+type BatchOp struct {
+	Key    []byte
+	Pos    *data.LogRecordPos // 写入的位置信息，删除时为空
+	Delete bool               // 是否是删除操作
+}
+
+// applyBatch 逐个执行批量操作，用于没有原生批量更新的索引
+func applyBatch(idx Indexer, ops []BatchOp) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	for i, op := range ops {
+		if op.Delete {
+			oldPositions[i], _ = idx.Delete(op.Key)
+		} else {
+			oldPositions[i] = idx.Put(op.Key, op.Pos)
+		}
+	}
+	return oldPositions
+}
+
+// MemSizer 可以统计内存占用的索引
+type MemSizer interface {
+	// MemSize 索引占用的内存大小，以字节为单位
+	MemSize() int64
+}
+
+// ErrReporter 读写磁盘可能失败的索引，例如 B+ 树
+// Indexer 的方法没有错误返回值，发生错误之后 Err 返回第一个错误，之后所有的操作都不再生效
+type ErrReporter interface {
+	Err() error
+}
+
 type IndexType = int8
 
 const (
@@ -35,19 +69,65 @@ const (
 
 	// BPTree B+树索引
 	BPTree
+
+	// Hash 哈希索引，只适合点查询
+	Hash
+
+	// ShardedBtree 分片 BTree 索引
+	ShardedBtree
+
+	// Compact 内存紧凑的索引
+	Compact
+
+	// Skiplist 并发跳表索引
+	Skiplist
 )
 
-// NewIndexer 根据类型初始化索引
-func NewIndexer(typ IndexType, dirPath string, sync bool) Indexer {
+// Comparator 比较两个 key 的大小，a < b 时返回负数，a == b 时返回 0，a > b 时返回正数
+// 对不同的 key 返回 0 时（例如忽略大小写的比较），索引按照字节序区分它们，它们仍然是不同的 key
+type Comparator func(a, b []byte) int
+
+// defaultComparator 未指定比较函数时按照字节序比较，比较函数认为相等的不同 key 也按照字节序排列
+func defaultComparator(cmp Comparator) Comparator {
+	if cmp == nil {
+		return bytes.Compare
+	}
+	return func(a, b []byte) int {
+		if c := cmp(a, b); c != 0 {
+			return c
+		}
+		return bytes.Compare(a, b)
+	}
+}
+
+// SupportsComparator 索引类型是否支持自定义比较函数
+// ART 和 B+ 树的顺序由底层的数据结构决定，只能按照字节序排列
+func SupportsComparator(typ IndexType) bool {
+	return typ != ART && typ != BPTree
+}
+
+// ErrUnsupportedIndexType 不支持的索引类型
+var ErrUnsupportedIndexType = errors.New("unsupported index type")
+
+// NewIndexer 根据类型初始化索引，cmp 为空时按照字节序排列 key
+func NewIndexer(typ IndexType, dirPath string, sync bool, cmp Comparator) (Indexer, error) {
 	switch typ {
 	case Btree:
-		return NewBTree()
+		return NewBTreeWithComparator(cmp), nil
 	case ART:
-		return NewART()
+		return NewART(), nil
 	case BPTree:
 		return NewBPlusTree(dirPath, sync)
+	case Hash:
+		return NewHashIndexWithComparator(cmp), nil
+	case ShardedBtree:
+		return NewShardedBTree(shardedBTreeShardCount, cmp), nil
+	case Compact:
+		return NewCompactIndex(compactShardCount, cmp), nil
+	case Skiplist:
+		return NewSkipListWithComparator(cmp), nil
 	default:
-		panic("unsupported index type")
+		return nil, ErrUnsupportedIndexType
 	}
 }
 
@@ -56,10 +136,6 @@ type Item struct {
 	pos *data.LogRecordPos
 }
 
-func (ai *Item) Less(bi btree.Item) bool {
-	return bytes.Compare(ai.key, bi.(*Item).key) == -1
-}
-
 type Iterator interface {
 	Rewind()                   // 重新回到迭代器的起点
 	Seek(key []byte)           // 查找第一个大于(或小于)等于目标的key
@@ -69,3 +145,47 @@ type Iterator interface {
 	Value() *data.LogRecordPos // 返回当前遍历位置的 Value 数据
 	Close()                    // 关闭迭代器并释放相应资源
 }
+
+// sliceIterator 遍历已排序的数据的迭代器，用于无法按需遍历的索引
+type sliceIterator struct {
+	currIndex int     // 当前遍历的下标位置
+	reverse   bool    // 是否是反向遍历
+	values    []*Item // key+位置索引信息，按照遍历的顺序排列
+	cmp       Comparator
+}
+
+func (si *sliceIterator) Rewind() {
+	si.currIndex = 0
+}
+
+func (si *sliceIterator) Seek(key []byte) {
+	if si.reverse {
+		si.currIndex = sort.Search(len(si.values), func(i int) bool {
+			return si.cmp(si.values[i].key, key) <= 0
+		})
+	} else {
+		si.currIndex = sort.Search(len(si.values), func(i int) bool {
+			return si.cmp(si.values[i].key, key) >= 0
+		})
+	}
+}
+
+func (si *sliceIterator) Next() {
+	si.currIndex += 1
+}
+
+func (si *sliceIterator) Valid() bool {
+	return si.currIndex < len(si.values)
+}
+
+func (si *sliceIterator) Key() []byte {
+	return si.values[si.currIndex].key
+}
+
+func (si *sliceIterator) Value() *data.LogRecordPos {
+	return si.values[si.currIndex].pos
+}
+
+func (si *sliceIterator) Close() {
+	si.values = nil
+}
@@ -0,0 +1,191 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"container/heap"
+	"hash/maphash"
+)
+
+// 分片 BTree 默认的分片数量
+const shardedBTreeShardCount = 16
+
+// ShardedBTree 分片 BTree 索引，按照 key 的哈希值将数据分散到多个 BTree 中
+// 每个分片单独加锁，多个写入者更新不同的 key 时不会互相阻塞
+type ShardedBTree struct {
+	seed   maphash.Seed
+	shards []*BTree
+	cmp    Comparator
+}
+
+// NewShardedBTree 初始化分片 BTree 索引，cmp 为空时按照字节序排列 key
+func NewShardedBTree(shardCount int, cmp Comparator) *ShardedBTree {
+	cmp = defaultComparator(cmp)
+	shards := make([]*BTree, shardCount)
+	for i := range shards {
+		shards[i] = NewBTreeWithComparator(cmp)
+	}
+	return &ShardedBTree{
+		seed:   maphash.MakeSeed(),
+		shards: shards,
+		cmp:    cmp,
+	}
+}
+
+// Put 向索引中存储 key 对应的位置信息
+func (sbt *ShardedBTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	return sbt.getShard(key).Put(key, pos)
+}
+
+// Get 根据 key 取出对应的位置信息
+func (sbt *ShardedBTree) Get(key []byte) *data.LogRecordPos {
+	return sbt.getShard(key).Get(key)
+}
+
+// Delete 根据 key 删除对应的位置信息
+func (sbt *ShardedBTree) Delete(key []byte) (*data.LogRecordPos, bool) {
+	return sbt.getShard(key).Delete(key)
+}
+
+// ApplyBatch 按顺序批量更新索引
+func (sbt *ShardedBTree) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	return applyBatch(sbt, ops)
+}
+
+// Size 索引中的数据量
+func (sbt *ShardedBTree) Size() int {
+	var size int
+	for _, shard := range sbt.shards {
+		size += shard.Size()
+	}
+	return size
+}
+
+// MemSize 索引占用内存的估计值
+func (sbt *ShardedBTree) MemSize() int64 {
+	var size int64
+	for _, shard := range sbt.shards {
+		size += shard.MemSize()
+	}
+	return size
+}
+
+func (sbt *ShardedBTree) Close() error {
+	return nil
+}
+
+// Iterator 初始化一个迭代器，用于遍历索引中的 key
+// 每个分片各自有序，通过堆将所有分片的迭代器归并成一个有序的迭代器
+func (sbt *ShardedBTree) Iterator(reverse bool) Iterator {
+	iters := make([]Iterator, len(sbt.shards))
+	for i, shard := range sbt.shards {
+		iters[i] = shard.Iterator(reverse)
+	}
+	return newMergingIterator(iters, reverse, sbt.cmp)
+}
+
+func (sbt *ShardedBTree) getShard(key []byte) *BTree {
+	return sbt.shards[maphash.Bytes(sbt.seed, key)%uint64(len(sbt.shards))]
+}
+
+// mergingIterator 归并多个有序迭代器的迭代器，各个迭代器中的 key 不能重复
+type mergingIterator struct {
+	iters []Iterator
+	heap  *iteratorHeap
+}
+
+func newMergingIterator(iters []Iterator, reverse bool, cmp Comparator) *mergingIterator {
+	mi := &mergingIterator{
+		iters: iters,
+		heap:  &iteratorHeap{reverse: reverse, cmp: cmp},
+	}
+	mi.rebuild()
+	return mi
+}
+
+func (mi *mergingIterator) Rewind() {
+	for _, iter := range mi.iters {
+		iter.Rewind()
+	}
+	mi.rebuild()
+}
+
+func (mi *mergingIterator) Seek(key []byte) {
+	for _, iter := range mi.iters {
+		iter.Seek(key)
+	}
+	mi.rebuild()
+}
+
+func (mi *mergingIterator) Next() {
+	top := mi.heap.iters[0]
+	top.Next()
+	if top.Valid() {
+		heap.Fix(mi.heap, 0)
+	} else {
+		heap.Pop(mi.heap)
+	}
+}
+
+func (mi *mergingIterator) Valid() bool {
+	return mi.heap.Len() > 0
+}
+
+func (mi *mergingIterator) Key() []byte {
+	return mi.heap.iters[0].Key()
+}
+
+func (mi *mergingIterator) Value() *data.LogRecordPos {
+	return mi.heap.iters[0].Value()
+}
+
+func (mi *mergingIterator) Close() {
+	for _, iter := range mi.iters {
+		iter.Close()
+	}
+	mi.heap.iters = nil
+}
+
+// rebuild 使用当前有效的迭代器重新建堆
+func (mi *mergingIterator) rebuild() {
+	mi.heap.iters = mi.heap.iters[:0]
+	for _, iter := range mi.iters {
+		if iter.Valid() {
+			mi.heap.iters = append(mi.heap.iters, iter)
+		}
+	}
+	heap.Init(mi.heap)
+}
+
+// iteratorHeap 按照迭代器当前 key 排序的堆，反向遍历时为大顶堆
+type iteratorHeap struct {
+	iters   []Iterator
+	reverse bool
+	cmp     Comparator
+}
+
+func (h *iteratorHeap) Len() int {
+	return len(h.iters)
+}
+
+func (h *iteratorHeap) Less(i, j int) bool {
+	cmp := h.cmp(h.iters[i].Key(), h.iters[j].Key())
+	if h.reverse {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func (h *iteratorHeap) Swap(i, j int) {
+	h.iters[i], h.iters[j] = h.iters[j], h.iters[i]
+}
+
+func (h *iteratorHeap) Push(x any) {
+	h.iters = append(h.iters, x.(Iterator))
+}
+
+func (h *iteratorHeap) Pop() any {
+	n := len(h.iters)
+	iter := h.iters[n-1]
+	h.iters = h.iters[:n-1]
+	return iter
+}
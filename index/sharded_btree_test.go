@@ -0,0 +1,92 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedBTree_Put(t *testing.T) {
+	sbt := NewShardedBTree(4, nil)
+	res1 := sbt.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	assert.Nil(t, res1)
+	res2 := sbt.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	assert.Nil(t, res2)
+
+	res3 := sbt.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1123, Offset: 990})
+	assert.Equal(t, uint32(1), res3.Fid)
+	assert.Equal(t, int64(12), res3.Offset)
+	assert.Equal(t, 2, sbt.Size())
+}
+
+func TestShardedBTree_Get(t *testing.T) {
+	sbt := NewShardedBTree(4, nil)
+	sbt.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	pos := sbt.Get([]byte("key-1"))
+	assert.NotNil(t, pos)
+	assert.Equal(t, uint32(1), pos.Fid)
+	assert.Equal(t, int64(12), pos.Offset)
+
+	pos1 := sbt.Get([]byte("not exist"))
+	assert.Nil(t, pos1)
+}
+
+func TestShardedBTree_Delete(t *testing.T) {
+	sbt := NewShardedBTree(4, nil)
+	res1, ok1 := sbt.Delete([]byte("not exist"))
+	assert.Nil(t, res1)
+	assert.False(t, ok1)
+
+	sbt.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	res2, ok2 := sbt.Delete([]byte("key-1"))
+	assert.True(t, ok2)
+	assert.Equal(t, int64(12), res2.Offset)
+	assert.Nil(t, sbt.Get([]byte("key-1")))
+	assert.Equal(t, 0, sbt.Size())
+}
+
+func TestShardedBTree_Iterator(t *testing.T) {
+	sbt := NewShardedBTree(4, nil)
+	// 1.索引为空的情况
+	iter1 := sbt.Iterator(false)
+	assert.False(t, iter1.Valid())
+	iter1.Close()
+
+	// 2.数据分散在多个分片中，按照 key 的顺序归并遍历
+	for i := 99; i >= 0; i-- {
+		sbt.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	iter2 := sbt.Iterator(false)
+	var i int
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter2.Key())
+		assert.Equal(t, int64(i), iter2.Value().Offset)
+		i++
+	}
+	assert.Equal(t, 100, i)
+	iter2.Close()
+
+	// 3.反向遍历
+	iter3 := sbt.Iterator(true)
+	i = 99
+	for iter3.Rewind(); iter3.Valid(); iter3.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter3.Key())
+		i--
+	}
+	assert.Equal(t, -1, i)
+	iter3.Close()
+
+	// 4.seek
+	iter4 := sbt.Iterator(false)
+	iter4.Seek([]byte("key-050"))
+	assert.Equal(t, []byte("key-050"), iter4.Key())
+	iter4.Next()
+	assert.Equal(t, []byte("key-051"), iter4.Key())
+	iter5 := sbt.Iterator(true)
+	iter5.Seek([]byte("key-050a"))
+	assert.Equal(t, []byte("key-050"), iter5.Key())
+	iter5.Next()
+	assert.Equal(t, []byte("key-049"), iter5.Key())
+}
@@ -0,0 +1,378 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// 跳表的最大层数
+	skiplistMaxLevel = 24
+
+	// 节点晋升到上一层的概率为 1/skiplistBranching
+	skiplistBranching = 4
+)
+
+// SkipList 并发跳表索引
+// 写入者之间通过互斥锁串行执行，Get 和迭代只通过原子操作读取节点，不需要加锁。
+// 每次写入都会生成一个新的版本，迭代器只读取创建时的快照版本，
+// 因此无需在创建时拷贝所有数据，也不受之后的写入影响
+type SkipList struct {
+	head  *skipNode
+	level atomic.Int32 // 当前的最大层数
+	size  atomic.Int64 // 有效 key 的数量
+
+	mu        sync.Mutex     // 写入者之间的互斥锁，同时保护下面的字段
+	seq       uint64         // 最新的版本号
+	snapshots map[uint64]int // 迭代器持有的快照版本及其数量
+	removed   []*skipNode    // 已删除但仍被快照引用，暂时不能从跳表中摘除的节点
+	rnd       *rand.Rand
+	cmp       Comparator
+}
+
+type skipNode struct {
+	key     []byte
+	version atomic.Pointer[skipVersion] // 最新的版本
+	next    []atomic.Pointer[skipNode]
+}
+
+// skipVersion key 的一个版本，按照版本号从新到旧链接
+type skipVersion struct {
+	pos   *data.LogRecordPos // 为 nil 时表示 key 在这个版本被删除
+	seq   uint64
+	older atomic.Pointer[skipVersion]
+}
+
+// NewSkipList 初始化跳表索引
+func NewSkipList() *SkipList {
+	return NewSkipListWithComparator(nil)
+}
+
+// NewSkipListWithComparator 初始化按照 cmp 排列 key 的跳表索引
+func NewSkipListWithComparator(cmp Comparator) *SkipList {
+	sl := &SkipList{
+		head:      &skipNode{next: make([]atomic.Pointer[skipNode], skiplistMaxLevel)},
+		snapshots: make(map[uint64]int),
+		rnd:       rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		cmp:       defaultComparator(cmp),
+	}
+	sl.level.Store(1)
+	return sl
+}
+
+// Put 向索引中存储 key 对应的位置信息
+func (sl *SkipList) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	sl.seq++
+	prevs := sl.newPrevs()
+	node := sl.findGreaterOrEqual(key, prevs)
+	if node != nil && sl.cmp(node.key, key) == 0 {
+		latest := node.version.Load()
+		sl.pushVersion(node, pos)
+		if latest.pos == nil {
+			sl.size.Add(1)
+		}
+		return latest.pos
+	}
+
+	level := sl.randomLevel()
+	node = &skipNode{key: key, next: make([]atomic.Pointer[skipNode], level)}
+	node.version.Store(&skipVersion{pos: pos, seq: sl.seq})
+	// 先设置新节点的后继，再链接到前驱节点上，并发的读取者总能看到完整的节点
+	for i := 0; i < level; i++ {
+		node.next[i].Store(prevs[i].next[i].Load())
+	}
+	for i := 0; i < level; i++ {
+		prevs[i].next[i].Store(node)
+	}
+	if int32(level) > sl.level.Load() {
+		sl.level.Store(int32(level))
+	}
+	sl.size.Add(1)
+	sl.cleanup()
+	return nil
+}
+
+// Get 根据 key 取出对应的位置信息
+func (sl *SkipList) Get(key []byte) *data.LogRecordPos {
+	node := sl.findGreaterOrEqual(key, nil)
+	if node == nil || sl.cmp(node.key, key) != 0 {
+		return nil
+	}
+	return node.version.Load().pos
+}
+
+// Delete 根据 key 删除对应的位置信息
+func (sl *SkipList) Delete(key []byte) (*data.LogRecordPos, bool) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	prevs := sl.newPrevs()
+	node := sl.findGreaterOrEqual(key, prevs)
+	if node == nil || sl.cmp(node.key, key) != 0 {
+		return nil, false
+	}
+	latest := node.version.Load()
+	if latest.pos == nil {
+		return nil, false
+	}
+
+	sl.seq++
+	if len(sl.snapshots) == 0 {
+		sl.unlink(node, prevs)
+	} else {
+		// 还有迭代器可能读到这个 key，先写入删除的版本，等快照释放之后再摘除节点
+		sl.pushVersion(node, nil)
+		sl.removed = append(sl.removed, node)
+	}
+	sl.size.Add(-1)
+	sl.cleanup()
+	return latest.pos, true
+}
+
+// ApplyBatch 按顺序批量更新索引
+func (sl *SkipList) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	return applyBatch(sl, ops)
+}
+
+// Size 索引中的数据量
+func (sl *SkipList) Size() int {
+	return int(sl.size.Load())
+}
+
+func (sl *SkipList) Close() error {
+	return nil
+}
+
+// Iterator 初始化一个迭代器，用于遍历索引中的 key
+// 迭代器持有一个快照，使用完之后需要调用 Close 释放
+func (sl *SkipList) Iterator(reverse bool) Iterator {
+	sl.mu.Lock()
+	snap := sl.seq
+	sl.snapshots[snap]++
+	sl.mu.Unlock()
+	return &skiplistIterator{sl: sl, snap: snap, reverse: reverse}
+}
+
+func (sl *SkipList) newPrevs() []*skipNode {
+	prevs := make([]*skipNode, skiplistMaxLevel)
+	for i := range prevs {
+		prevs[i] = sl.head
+	}
+	return prevs
+}
+
+// findGreaterOrEqual 查找第一个大于等于 key 的节点，prevs 不为空时记录每一层的前驱节点
+func (sl *SkipList) findGreaterOrEqual(key []byte, prevs []*skipNode) *skipNode {
+	x := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		for {
+			next := x.next[i].Load()
+			if next == nil || sl.cmp(next.key, key) >= 0 {
+				break
+			}
+			x = next
+		}
+		if prevs != nil {
+			prevs[i] = x
+		}
+	}
+	return x.next[0].Load()
+}
+
+// findLessThan 查找最后一个小于 key 的节点
+func (sl *SkipList) findLessThan(key []byte) *skipNode {
+	x := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		for {
+			next := x.next[i].Load()
+			if next == nil || sl.cmp(next.key, key) >= 0 {
+				break
+			}
+			x = next
+		}
+	}
+	if x == sl.head {
+		return nil
+	}
+	return x
+}
+
+// findLast 查找最后一个节点
+func (sl *SkipList) findLast() *skipNode {
+	x := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		for next := x.next[i].Load(); next != nil; next = x.next[i].Load() {
+			x = next
+		}
+	}
+	if x == sl.head {
+		return nil
+	}
+	return x
+}
+
+func (sl *SkipList) randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && sl.rnd.IntN(skiplistBranching) == 0 {
+		level++
+	}
+	return level
+}
+
+// pushVersion 写入 key 的新版本，并丢弃所有快照都不再需要的旧版本，需要持有写锁
+func (sl *SkipList) pushVersion(node *skipNode, pos *data.LogRecordPos) {
+	v := &skipVersion{pos: pos, seq: sl.seq}
+	v.older.Store(node.version.Load())
+	node.version.Store(v)
+
+	minSnap, ok := sl.minSnapshot()
+	if !ok {
+		v.older.Store(nil)
+		return
+	}
+	// 最旧的快照能看到的版本之前的版本都不再需要
+	for ; v != nil; v = v.older.Load() {
+		if v.seq <= minSnap {
+			v.older.Store(nil)
+			return
+		}
+	}
+}
+
+// unlink 将节点从跳表中摘除，节点自身的后继保持不变，正在访问它的读取者可以继续向后遍历
+func (sl *SkipList) unlink(node *skipNode, prevs []*skipNode) {
+	for i := range node.next {
+		if prevs[i].next[i].Load() == node {
+			prevs[i].next[i].Store(node.next[i].Load())
+		}
+	}
+}
+
+// cleanup 摘除所有快照都已经看不到的被删除节点，需要持有写锁
+func (sl *SkipList) cleanup() {
+	if len(sl.removed) == 0 {
+		return
+	}
+	minSnap, hasSnap := sl.minSnapshot()
+	kept := sl.removed[:0]
+	for _, node := range sl.removed {
+		latest := node.version.Load()
+		if latest.pos != nil {
+			// 删除之后又重新写入了
+			continue
+		}
+		if hasSnap && latest.seq > minSnap {
+			kept = append(kept, node)
+			continue
+		}
+		prevs := sl.newPrevs()
+		if sl.findGreaterOrEqual(node.key, prevs) == node {
+			sl.unlink(node, prevs)
+		}
+	}
+	for i := len(kept); i < len(sl.removed); i++ {
+		sl.removed[i] = nil
+	}
+	sl.removed = kept
+}
+
+func (sl *SkipList) minSnapshot() (uint64, bool) {
+	var minSnap uint64
+	var ok bool
+	for snap := range sl.snapshots {
+		if !ok || snap < minSnap {
+			minSnap, ok = snap, true
+		}
+	}
+	return minSnap, ok
+}
+
+func (sl *SkipList) releaseSnapshot(snap uint64) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if sl.snapshots[snap]--; sl.snapshots[snap] <= 0 {
+		delete(sl.snapshots, snap)
+	}
+	sl.cleanup()
+}
+
+// skiplistIterator 跳表迭代器，只遍历创建时快照中的数据
+type skiplistIterator struct {
+	sl      *SkipList
+	snap    uint64             // 快照的版本号
+	reverse bool               // 是否是反向遍历
+	curr    *skipNode          // 当前遍历的节点
+	pos     *data.LogRecordPos // 当前节点在快照中的位置信息
+	closed  bool
+}
+
+func (sit *skiplistIterator) Rewind() {
+	if sit.reverse {
+		sit.curr = sit.sl.findLast()
+	} else {
+		sit.curr = sit.sl.head.next[0].Load()
+	}
+	sit.skipInvisible()
+}
+
+func (sit *skiplistIterator) Seek(key []byte) {
+	sit.curr = sit.sl.findGreaterOrEqual(key, nil)
+	if sit.reverse && (sit.curr == nil || sit.sl.cmp(sit.curr.key, key) != 0) {
+		sit.curr = sit.sl.findLessThan(key)
+	}
+	sit.skipInvisible()
+}
+
+func (sit *skiplistIterator) Next() {
+	sit.step()
+	sit.skipInvisible()
+}
+
+func (sit *skiplistIterator) Valid() bool {
+	return sit.curr != nil
+}
+
+func (sit *skiplistIterator) Key() []byte {
+	return sit.curr.key
+}
+
+func (sit *skiplistIterator) Value() *data.LogRecordPos {
+	return sit.pos
+}
+
+func (sit *skiplistIterator) Close() {
+	if sit.closed {
+		return
+	}
+	sit.closed = true
+	sit.curr = nil
+	sit.sl.releaseSnapshot(sit.snap)
+}
+
+func (sit *skiplistIterator) step() {
+	if sit.reverse {
+		sit.curr = sit.sl.findLessThan(sit.curr.key)
+	} else {
+		sit.curr = sit.curr.next[0].Load()
+	}
+}
+
+// skipInvisible 跳过在快照中不存在或已被删除的节点
+func (sit *skiplistIterator) skipInvisible() {
+	for ; sit.curr != nil; sit.step() {
+		for v := sit.curr.version.Load(); v != nil; v = v.older.Load() {
+			if v.seq <= sit.snap {
+				if v.pos != nil {
+					sit.pos = v.pos
+					return
+				}
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkipList_Put(t *testing.T) {
+	sl := NewSkipList()
+	res1 := sl.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	assert.Nil(t, res1)
+	res2 := sl.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	assert.Nil(t, res2)
+
+	res3 := sl.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1123, Offset: 990})
+	assert.Equal(t, uint32(1), res3.Fid)
+	assert.Equal(t, int64(12), res3.Offset)
+	assert.Equal(t, 2, sl.Size())
+}
+
+func TestSkipList_Get(t *testing.T) {
+	sl := NewSkipList()
+	sl.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	pos := sl.Get([]byte("key-1"))
+	assert.NotNil(t, pos)
+	assert.Equal(t, int64(12), pos.Offset)
+
+	pos1 := sl.Get([]byte("not exist"))
+	assert.Nil(t, pos1)
+
+	sl.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1123, Offset: 990})
+	pos2 := sl.Get([]byte("key-1"))
+	assert.Equal(t, int64(990), pos2.Offset)
+}
+
+func TestSkipList_Delete(t *testing.T) {
+	sl := NewSkipList()
+	res1, ok1 := sl.Delete([]byte("not exist"))
+	assert.Nil(t, res1)
+	assert.False(t, ok1)
+
+	sl.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	res2, ok2 := sl.Delete([]byte("key-1"))
+	assert.True(t, ok2)
+	assert.Equal(t, int64(12), res2.Offset)
+	assert.Nil(t, sl.Get([]byte("key-1")))
+	assert.Equal(t, 0, sl.Size())
+
+	res3, ok3 := sl.Delete([]byte("key-1"))
+	assert.Nil(t, res3)
+	assert.False(t, ok3)
+}
+
+func TestSkipList_Iterator(t *testing.T) {
+	sl := NewSkipList()
+	// 1.索引为空的情况
+	iter1 := sl.Iterator(false)
+	iter1.Rewind()
+	assert.False(t, iter1.Valid())
+	iter1.Close()
+
+	// 2.有多条数据，按照 key 的顺序遍历
+	for i := 99; i >= 0; i-- {
+		sl.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	iter2 := sl.Iterator(false)
+	var i int
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter2.Key())
+		assert.Equal(t, int64(i), iter2.Value().Offset)
+		i++
+	}
+	assert.Equal(t, 100, i)
+	iter2.Close()
+
+	// 3.反向遍历
+	iter3 := sl.Iterator(true)
+	i = 99
+	for iter3.Rewind(); iter3.Valid(); iter3.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter3.Key())
+		i--
+	}
+	assert.Equal(t, -1, i)
+	iter3.Close()
+
+	// 4.seek
+	iter4 := sl.Iterator(false)
+	iter4.Seek([]byte("key-050"))
+	assert.Equal(t, []byte("key-050"), iter4.Key())
+	iter4.Close()
+	iter5 := sl.Iterator(true)
+	iter5.Seek([]byte("key-050a"))
+	assert.Equal(t, []byte("key-050"), iter5.Key())
+	iter5.Next()
+	assert.Equal(t, []byte("key-049"), iter5.Key())
+	iter5.Close()
+}
+
+func TestSkipList_IteratorSnapshot(t *testing.T) {
+	sl := NewSkipList()
+	for i := 0; i < 10; i++ {
+		sl.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	iter := sl.Iterator(false)
+
+	// 创建迭代器之后的写入和删除对迭代器不可见
+	sl.Put([]byte("key-000"), &data.LogRecordPos{Fid: 2, Offset: 100})
+	sl.Put([]byte("key-100"), &data.LogRecordPos{Fid: 2, Offset: 100})
+	sl.Delete([]byte("key-005"))
+	assert.Nil(t, sl.Get([]byte("key-005")))
+
+	var i int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%03d", i)), iter.Key())
+		assert.Equal(t, uint32(1), iter.Value().Fid)
+		i++
+	}
+	assert.Equal(t, 10, i)
+	iter.Close()
+
+	// 快照释放之后被删除的节点从跳表中摘除
+	assert.Empty(t, sl.removed)
+	assert.Equal(t, 10, sl.Size())
+	iter2 := sl.Iterator(false)
+	i = 0
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		i++
+	}
+	assert.Equal(t, 10, i)
+	iter2.Close()
+}
+
+func TestSkipList_Concurrent(t *testing.T) {
+	sl := NewSkipList()
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < 1000; i += 4 {
+				key := []byte(fmt.Sprintf("key-%04d", i))
+				sl.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+				if i%3 == 0 {
+					sl.Delete(key)
+				}
+			}
+		}(w)
+	}
+	// 写入的同时无锁读取和迭代
+	for r := 0; r < 2; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				iter := sl.Iterator(n%2 == 1)
+				var prev []byte
+				for iter.Rewind(); iter.Valid(); iter.Next() {
+					if prev != nil {
+						assert.NotEqual(t, prev, iter.Key())
+					}
+					prev = iter.Key()
+					sl.Get(iter.Key())
+				}
+				iter.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 666, sl.Size())
+	for i := 0; i < 1000; i++ {
+		pos := sl.Get([]byte(fmt.Sprintf("key-%04d", i)))
+		if i%3 == 0 {
+			assert.Nil(t, pos)
+		} else {
+			assert.Equal(t, int64(i), pos.Offset)
+		}
+	}
+}
@@ -1,69 +1,236 @@
 package bitcaskgo
 
 import (
+	"bitcask-go/data"
 	"bitcask-go/index"
 	"bytes"
+	"time"
 )
 
 type Iterator struct {
-	indexIter index.Iterator // 索引迭代器
-	db        *DB            // 数据库实例
-	options   IteratorOptions
+	indexIter    index.Iterator // 索引迭代器
+	db           *DB            // 数据库实例
+	options      IteratorOptions
+	lowerBound   []byte // 遍历范围的下界(包含)，已经合并了前缀对应的范围
+	upperBound   []byte // 遍历范围的上界(不包含)，已经合并了前缀对应的范围
+	filterPrefix bool   // 自定义排序时前缀相同的 key 不一定连续，需要逐个过滤
+
+	// 开启预读时，索引迭代器位于预读窗口之后，当前位置由窗口决定
+	prefetched  []prefetchEntry
+	prefetchIdx int
+}
+
+// prefetchEntry 预读窗口中的一条数据
+type prefetchEntry struct {
+	key   []byte
+	pos   *data.LogRecordPos
+	value []byte
+	err   error
 }
 
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
 	indexIter := db.index.Iterator(opts.Reverse)
-	return &Iterator{
-		indexIter: indexIter,
-		db:        db,
-		options:   opts,
+	it := &Iterator{
+		indexIter:  indexIter,
+		db:         db,
+		options:    opts,
+		lowerBound: opts.LowerBound,
+		upperBound: opts.UpperBound,
+	}
+	if len(opts.Prefix) > 0 {
+		if db.options.Comparator == nil {
+			// 按照字节序排列时，前缀相同的 key 是连续的，可以转换为范围
+			if it.lowerBound == nil || bytes.Compare(opts.Prefix, it.lowerBound) > 0 {
+				it.lowerBound = opts.Prefix
+			}
+			upper := prefixUpperBound(opts.Prefix)
+			if upper != nil && (it.upperBound == nil || bytes.Compare(upper, it.upperBound) < 0) {
+				it.upperBound = upper
+			}
+		} else {
+			it.filterPrefix = true
+		}
 	}
+	it.Rewind()
+	return it
 }
 
 func (it *Iterator) Rewind() {
-	it.indexIter.Rewind()
-	it.skipToNext()
+	it.rewind()
+	it.prefetch()
 }
 
 func (it *Iterator) Seek(key []byte) {
-	it.indexIter.Seek(key)
-	it.skipToNext()
+	it.seek(key)
+	it.prefetch()
 }
 
 func (it *Iterator) Next() {
-	it.indexIter.Next()
-	it.skipToNext()
+	if it.options.PrefetchSize <= 0 {
+		it.next()
+		return
+	}
+	it.prefetchIdx++
+	if it.prefetchIdx >= len(it.prefetched) {
+		it.prefetch()
+	}
 }
 
+// Valid 是否还有数据，超出遍历范围时返回 false
 func (it *Iterator) Valid() bool {
-	return it.indexIter.Valid()
+	if it.options.PrefetchSize > 0 {
+		return it.prefetchIdx < len(it.prefetched)
+	}
+	return it.valid()
 }
 
 func (it *Iterator) Key() []byte {
+	if it.options.PrefetchSize > 0 {
+		return it.prefetched[it.prefetchIdx].key
+	}
 	return it.indexIter.Key()
 }
 
 func (it *Iterator) Value() ([]byte, error) {
+	if it.options.PrefetchSize > 0 {
+		entry := it.prefetched[it.prefetchIdx]
+		return entry.value, entry.err
+	}
 	logRecordPos := it.indexIter.Value()
 	it.db.mu.RLock()
 	defer it.db.mu.RUnlock()
 	return it.db.getValueByPosition(logRecordPos)
 }
 
+// ValueSize 当前 value 的长度，只需要读取索引
+func (it *Iterator) ValueSize() (int, error) {
+	return it.db.valueSizeByPosition(it.position())
+}
+
+// ModTime 当前 key 最后一次写入的时间，没有记录写入时间的旧数据返回零值
+func (it *Iterator) ModTime() time.Time {
+	return modTimeByPosition(it.position())
+}
+
 func (it *Iterator) Close() {
 	it.indexIter.Close()
+	it.prefetched = nil
 }
 
-func (it *Iterator) skipToNext() {
-	prefixlen := len(it.options.Prefix)
-	if prefixlen == 0 {
+func (it *Iterator) position() *data.LogRecordPos {
+	if it.options.PrefetchSize > 0 {
+		return it.prefetched[it.prefetchIdx].pos
+	}
+	return it.indexIter.Value()
+}
+
+// prefetch 从索引迭代器的当前位置取出后续的 key，并批量读取它们的 value
+func (it *Iterator) prefetch() {
+	if it.options.PrefetchSize <= 0 {
 		return
 	}
+	it.prefetchIdx = 0
+	it.prefetched = it.prefetched[:0]
+	positions := make([]*data.LogRecordPos, 0, it.options.PrefetchSize)
+	for ; it.valid() && len(it.prefetched) < it.options.PrefetchSize; it.next() {
+		pos := it.indexIter.Value()
+		it.prefetched = append(it.prefetched, prefetchEntry{key: it.indexIter.Key(), pos: pos})
+		positions = append(positions, pos)
+	}
+	if len(positions) == 0 {
+		return
+	}
+
+	it.db.mu.RLock()
+	values, errs := it.db.readValues(positions)
+	it.db.mu.RUnlock()
+	for i := range it.prefetched {
+		it.prefetched[i].value, it.prefetched[i].err = values[i], errs[i]
+	}
+}
+
+func (it *Iterator) rewind() {
+	switch {
+	case it.options.Reverse && it.upperBound != nil:
+		it.seekBefore(it.upperBound)
+	case !it.options.Reverse && it.lowerBound != nil:
+		it.indexIter.Seek(it.lowerBound)
+	default:
+		it.indexIter.Rewind()
+	}
+	it.skipToNext()
+}
+
+func (it *Iterator) seek(key []byte) {
+	if it.options.Reverse {
+		if it.upperBound != nil && it.db.compareKeys(key, it.upperBound) >= 0 {
+			it.seekBefore(it.upperBound)
+		} else {
+			it.indexIter.Seek(key)
+		}
+	} else {
+		if it.lowerBound != nil && it.db.compareKeys(key, it.lowerBound) < 0 {
+			key = it.lowerBound
+		}
+		it.indexIter.Seek(key)
+	}
+	it.skipToNext()
+}
+
+func (it *Iterator) next() {
+	it.indexIter.Next()
+	it.skipToNext()
+}
+
+// valid 索引迭代器是否还有数据，超出遍历范围时返回 false
+func (it *Iterator) valid() bool {
+	if !it.indexIter.Valid() {
+		return false
+	}
+	if it.options.Reverse {
+		return it.lowerBound == nil || it.db.compareKeys(it.indexIter.Key(), it.lowerBound) >= 0
+	}
+	return it.upperBound == nil || it.db.compareKeys(it.indexIter.Key(), it.upperBound) < 0
+}
+
+// seekBefore 反向遍历时定位到小于 key 的最大的 key
+func (it *Iterator) seekBefore(key []byte) {
+	it.indexIter.Seek(key)
+	if it.indexIter.Valid() && it.db.compareKeys(it.indexIter.Key(), key) == 0 {
+		it.indexIter.Next()
+	}
+}
 
-	for ; it.indexIter.Valid(); it.indexIter.Next() {
-		key := it.indexIter.Key()
-		if prefixlen <= len(key) && bytes.Equal(it.options.Prefix, key[:prefixlen]) {
+func (it *Iterator) skipToNext() {
+	if !it.filterPrefix {
+		return
+	}
+	for ; it.valid(); it.indexIter.Next() {
+		if bytes.HasPrefix(it.indexIter.Key(), it.options.Prefix) {
 			break
 		}
 	}
 }
+
+// compareKeys 按照索引中 key 的顺序比较两个 key
+func (db *DB) compareKeys(a, b []byte) int {
+	// 和索引一样，比较函数认为相等的不同 key 按照字节序排列
+	if db.options.Comparator != nil {
+		if c := db.options.Comparator(a, b); c != 0 {
+			return c
+		}
+	}
+	return bytes.Compare(a, b)
+}
+
+// prefixUpperBound 返回大于所有以 prefix 开头的 key 的最小的 key，prefix 全部为 0xff 时没有上界，返回 nil
+func prefixUpperBound(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			upper := append([]byte(nil), prefix[:i+1]...)
+			upper[i]++
+			return upper
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package bitcaskgo
 
 import (
 	"bitcask-go/utils"
+	"bytes"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -89,3 +90,209 @@ func TestDB_NewIterator_Multi_Values(t *testing.T) {
 	}
 	iter3.Close()
 }
+
+func TestDB_Iterator_Comparator(t *testing.T) {
+	// 按照字节序倒序排列
+	descending := func(a, b []byte) int {
+		return bytes.Compare(b, a)
+	}
+	for _, typ := range []IndexerType{BTree, Hash, ShardedBTree, Compact, SkipList} {
+		opts := DefaultOptions
+		opts.IndexType = typ
+		opts.Comparator = descending
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		for _, key := range []string{"b", "d", "a", "e", "c"} {
+			assert.Nil(t, db.Put([]byte(key), utils.RandomValue(10)))
+		}
+
+		var keys []string
+		iter := db.NewIterator(DefaultIteratorOptions)
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		assert.Equal(t, []string{"e", "d", "c", "b", "a"}, keys)
+
+		iter.Seek([]byte("c"))
+		assert.True(t, iter.Valid())
+		assert.Equal(t, []byte("c"), iter.Key())
+		iter.Seek([]byte("cc"))
+		assert.True(t, iter.Valid())
+		assert.Equal(t, []byte("c"), iter.Key())
+		iter.Close()
+
+		keys = nil
+		iter = db.NewIterator(IteratorOptions{Reverse: true})
+		for iter.Seek([]byte("c")); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		assert.Equal(t, []string{"c", "d", "e"}, keys)
+		iter.Close()
+		destroyDB(db)
+	}
+
+	// ART 和 B+ 树的顺序由底层数据结构决定
+	for _, typ := range []IndexerType{ART, BPTree} {
+		opts := DefaultOptions
+		opts.IndexType = typ
+		opts.Comparator = descending
+		_, err := Open(opts)
+		assert.Equal(t, ErrComparatorUnsupported, err)
+	}
+}
+
+func TestDB_Iterator_ComparatorTies(t *testing.T) {
+	// 忽略大小写，不同的 key 可能相等
+	caseInsensitive := func(a, b []byte) int {
+		return bytes.Compare(bytes.ToLower(a), bytes.ToLower(b))
+	}
+	for _, typ := range []IndexerType{BTree, Hash, ShardedBTree, Compact, SkipList} {
+		opts := DefaultOptions
+		opts.IndexType = typ
+		opts.Comparator = caseInsensitive
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		for _, key := range []string{"b", "C", "a", "B", "c"} {
+			assert.Nil(t, db.Put([]byte(key), []byte("value-"+key)))
+		}
+		// 相等的 key 仍然是不同的 key
+		for _, key := range []string{"a", "b", "B", "c", "C"} {
+			value, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("value-"+key), value)
+		}
+		stat, err := db.Stat()
+		assert.Nil(t, err)
+		assert.Equal(t, uint(5), stat.KeyNum)
+
+		var keys []string
+		iter := db.NewIterator(DefaultIteratorOptions)
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		assert.Equal(t, []string{"a", "B", "b", "C", "c"}, keys)
+		iter.Close()
+
+		// 边界和索引使用相同的顺序
+		keys = nil
+		iter = db.NewIterator(IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("c")})
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		assert.Equal(t, []string{"b", "C"}, keys)
+		iter.Close()
+
+		assert.Nil(t, db.Delete([]byte("B")))
+		value, err := db.Get([]byte("b"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("value-b"), value)
+		_, err = db.Get([]byte("B"))
+		assert.Equal(t, ErrKeyNotFound, err)
+		destroyDB(db)
+	}
+}
+
+func TestDB_Iterator_Bounds(t *testing.T) {
+	for _, typ := range []IndexerType{BTree, ART, BPTree, SkipList} {
+		opts := DefaultOptions
+		opts.IndexType = typ
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		for _, key := range []string{"a", "b1", "b2", "b3", "c", "d"} {
+			assert.Nil(t, db.Put([]byte(key), utils.RandomValue(10)))
+		}
+		collect := func(iterOpts IteratorOptions) []string {
+			var keys []string
+			iter := db.NewIterator(iterOpts)
+			defer iter.Close()
+			for ; iter.Valid(); iter.Next() {
+				keys = append(keys, string(iter.Key()))
+			}
+			return keys
+		}
+
+		assert.Equal(t, []string{"b1", "b2", "b3", "c"},
+			collect(IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("d")}))
+		assert.Equal(t, []string{"c", "b3", "b2", "b1"},
+			collect(IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("d"), Reverse: true}))
+		assert.Equal(t, []string{"b1", "b2", "b3"}, collect(IteratorOptions{Prefix: []byte("b")}))
+		assert.Equal(t, []string{"b3", "b2", "b1"}, collect(IteratorOptions{Prefix: []byte("b"), Reverse: true}))
+		assert.Equal(t, []string{"b2"},
+			collect(IteratorOptions{Prefix: []byte("b"), LowerBound: []byte("b2"), UpperBound: []byte("b3")}))
+		assert.Nil(t, collect(IteratorOptions{LowerBound: []byte("e")}))
+
+		// Seek 不会越过范围
+		iter := db.NewIterator(IteratorOptions{LowerBound: []byte("b2"), UpperBound: []byte("c")})
+		iter.Seek([]byte("a"))
+		assert.Equal(t, []byte("b2"), iter.Key())
+		iter.Seek([]byte("c"))
+		assert.False(t, iter.Valid())
+		iter.Close()
+
+		iter = db.NewIterator(IteratorOptions{LowerBound: []byte("b2"), UpperBound: []byte("c"), Reverse: true})
+		iter.Seek([]byte("z"))
+		assert.Equal(t, []byte("b3"), iter.Key())
+		iter.Seek([]byte("b1"))
+		assert.False(t, iter.Valid())
+		iter.Close()
+		destroyDB(db)
+	}
+}
+
+func TestDB_Iterator_Prefetch(t *testing.T) {
+	opts := DefaultOptions
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 1000; i++ {
+		value := utils.RandomValue(64)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+		values[string(utils.GetTestKey(i))] = value
+	}
+	// 覆盖写入一部分数据，打乱 key 的顺序和数据在文件中的顺序
+	for i := 0; i < 1000; i += 3 {
+		value := utils.RandomValue(32)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+		values[string(utils.GetTestKey(i))] = value
+	}
+
+	for _, reverse := range []bool{false, true} {
+		iter := db.NewIterator(IteratorOptions{Reverse: reverse, PrefetchSize: 64})
+		var count int
+		var prevKey []byte
+		for ; iter.Valid(); iter.Next() {
+			if prevKey != nil {
+				assert.Equal(t, reverse, bytes.Compare(prevKey, iter.Key()) > 0)
+			}
+			prevKey = iter.Key()
+			value, err := iter.Value()
+			assert.Nil(t, err)
+			assert.Equal(t, values[string(iter.Key())], value)
+			size, err := iter.ValueSize()
+			assert.Nil(t, err)
+			assert.Equal(t, len(value), size)
+			count++
+		}
+		assert.Equal(t, 1000, count)
+
+		iter.Seek(utils.GetTestKey(500))
+		assert.True(t, iter.Valid())
+		assert.Equal(t, utils.GetTestKey(500), iter.Key())
+		iter.Close()
+	}
+
+	var count int
+	err = db.Fold(func(key []byte, value []byte) bool {
+		assert.Equal(t, values[string(key)], value)
+		count++
+		return count < 600
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 600, count)
+}
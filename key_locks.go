@@ -0,0 +1,74 @@
+package bitcaskgo
+
+import (
+	"hash/maphash"
+	"sort"
+	"sync"
+)
+
+// 分段锁的数量
+const keyLockCount = 256
+
+// keyLocks 按照 key 的哈希值分段的锁
+// 写入时持有 key 所在分段的锁，保证同一个 key 的追加写入顺序和索引更新顺序一致，
+// 这样更新索引时就不再需要持有数据库的全局锁
+type keyLocks struct {
+	seed  maphash.Seed
+	locks []*sync.Mutex
+}
+
+func newKeyLocks() *keyLocks {
+	locks := make([]*sync.Mutex, keyLockCount)
+	for i := range locks {
+		locks[i] = new(sync.Mutex)
+	}
+	return &keyLocks{
+		seed:  maphash.MakeSeed(),
+		locks: locks,
+	}
+}
+
+// lock 锁住 key 所在的分段，返回解锁函数
+func (kl *keyLocks) lock(key []byte) func() {
+	mu := kl.locks[kl.slot(key)]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// lockKeys 锁住所有 key 所在的分段，按照分段顺序加锁避免死锁，返回解锁函数
+func (kl *keyLocks) lockKeys(keys [][]byte) func() {
+	seen := make(map[int]struct{}, len(keys))
+	slots := make([]int, 0, len(keys))
+	for _, key := range keys {
+		slot := kl.slot(key)
+		if _, ok := seen[slot]; !ok {
+			seen[slot] = struct{}{}
+			slots = append(slots, slot)
+		}
+	}
+	sort.Ints(slots)
+	for _, slot := range slots {
+		kl.locks[slot].Lock()
+	}
+	return func() {
+		for i := len(slots) - 1; i >= 0; i-- {
+			kl.locks[slots[i]].Unlock()
+		}
+	}
+}
+
+// lockAll 按照分段顺序锁住所有分段，返回解锁函数
+func (kl *keyLocks) lockAll() func() {
+	for _, mu := range kl.locks {
+		mu.Lock()
+	}
+	return func() {
+		for i := len(kl.locks) - 1; i >= 0; i-- {
+			kl.locks[i].Unlock()
+		}
+	}
+}
+
+func (kl *keyLocks) slot(key []byte) int {
+	return int(maphash.Bytes(kl.seed, key) % keyLockCount)
+}
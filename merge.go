@@ -2,6 +2,8 @@ package bitcaskgo
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
 	"bitcask-go/utils"
 	"io"
 	"os"
@@ -9,15 +11,20 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"sync/atomic"
+	"time"
 )
 
 const (
 	mergeDirName     = "-merge"
 	mergeFinishedKey = "merge.finished"
+
+	// 批量更新索引时每批的数量
+	indexBatchSize = 1024
 )
 
 // Merge 清理无效数据，生成hint文件
-func (db *DB) Merge() error {
+func (db *DB) Merge() (err error) {
 	if db.activeFile == nil {
 		return nil
 	}
@@ -35,7 +42,8 @@ func (db *DB) Merge() error {
 		return err
 	}
 
-	if float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMerGeRatio {
+	reclaimSize := atomic.LoadInt64(&db.reclaimSize)
+	if float32(reclaimSize)/float32(totalSize) < db.options.DataFileMerGeRatio {
 		db.mu.Unlock()
 		return ErrMergeRatioUnreached
 	}
@@ -46,7 +54,7 @@ func (db *DB) Merge() error {
 		db.mu.Unlock()
 		return err
 	}
-	if uint64(totalSize-db.reclaimSize) >= availableDiskSize {
+	if uint64(totalSize-reclaimSize) >= availableDiskSize {
 		db.mu.Unlock()
 		return ErrNoEnoughSpaceForMerge
 	}
@@ -55,17 +63,8 @@ func (db *DB) Merge() error {
 		db.isMerging = false
 	}()
 
-	// sync active file
-	if err := db.activeFile.Sync(); err != nil {
-		db.mu.Unlock()
-		return err
-	}
-
-	// active file -> old file
-	db.olderFiles[db.activeFile.FileId] = db.activeFile
-
-	// new active file
-	if err := db.setActiveDataFile(); err != nil {
+	// sync active file, active file -> old file, and open a new active file
+	if err := db.rotateActiveFile(); err != nil {
 		db.mu.Unlock()
 		return err
 	}
@@ -79,6 +78,14 @@ func (db *DB) Merge() error {
 	}
 	db.mu.Unlock()
 
+	info := MergeInfo{FileNum: len(mergeFiles), ReclaimableSize: reclaimSize}
+	db.onMergeBegin(info)
+	start := time.Now()
+	defer func() {
+		info.Duration, info.Err = time.Since(start), err
+		db.onMergeEnd(info)
+	}()
+
 	// sort merge file
 	sort.Slice(mergeFiles, func(i, j int) bool {
 		return mergeFiles[i].FileId < mergeFiles[j].FileId
@@ -98,35 +105,50 @@ func (db *DB) Merge() error {
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrites = false
+	// the temp db is an implementation detail, keep its events out of the listener
+	mergeOptions.EventListener = nil
+	mergeOptions.Logger = db.logger.With("merge", true)
 	mergeDB, err := Open(mergeOptions)
-	defer func() {
-		if err := mergeDB.Close(); err != nil {
-			panic(err)
-		}
-	}()
 	if err != nil {
 		return err
 	}
-
-	// open hint file
-	hintFile, err := data.OpenHintFile(mergePath)
 	defer func() {
-		if err := hintFile.Close(); err != nil {
-			panic(err)
+		if closeErr := mergeDB.Close(); closeErr != nil && err == nil {
+			err = closeErr
 		}
 	}()
+	// writes of merge db are rate limited as background work
+	mergeDB.writeLimiter = db.rateLimiter
+
+	// open hint file
+	hintFile, err := data.OpenHintFile(mergePath)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if closeErr := hintFile.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+	hintFile.IoManager = fio.NewRateLimitedIOManager(hintFile.IoManager, db.rateLimiter)
 
+	// bytes scanned from the merged files and bytes kept in the merge db
+	var readSize, keptSize int64
 	for _, dataFile := range mergeFiles {
+		reader, closeReader, err := db.openMergeReader(dataFile)
+		if err != nil {
+			return err
+		}
+
 		var offset int64 = 0
 		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			logRecord, size, err := reader.ReadLogRecord(offset)
 			if err != nil {
 				if err == io.EOF {
 					break
 				}
+				db.reportCorruption(dataFile.FileId, offset, err)
+				_ = closeReader()
 				return err
 			}
 			// parse key
@@ -139,17 +161,29 @@ func (db *DB) Merge() error {
 				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
 				pos, err := mergeDB.appendLogRecord(logRecord)
 				if err != nil {
+					_ = closeReader()
 					return err
 				}
 				// update index to Hint file
 				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
+					_ = closeReader()
 					return err
 				}
+				keptSize += int64(pos.Size)
 
 			}
 
 			offset += size
 		}
+		readSize += offset
+		if err := closeReader(); err != nil {
+			return err
+		}
+	}
+	info.ReclaimedSize = readSize - keptSize
+	// a failed index hides live records, the merge result must not be applied
+	if err := db.indexErr(); err != nil {
+		return err
 	}
 
 	//sync hint file
@@ -161,14 +195,14 @@ func (db *DB) Merge() error {
 		return err
 	}
 	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
-	defer func() {
-		if err := mergeFinishedFile.Close(); err != nil {
-			panic(err)
-		}
-	}()
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if closeErr := mergeFinishedFile.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
 	mergeFinRecord := &data.LogRecord{
 		Key:   []byte(mergeFinishedKey),
 		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
@@ -184,6 +218,19 @@ func (db *DB) Merge() error {
 	return nil
 }
 
+// openMergeReader 打开 merge 时扫描数据文件使用的 reader，读取速率受到后台任务限速器的限制
+// 配置了 DirectIOMerge 时单独打开 Direct I/O 文件扫描，避免大量读取污染页缓存
+func (db *DB) openMergeReader(dataFile *data.DataFile) (*data.DataFile, func() error, error) {
+	if db.options.DirectIO&DirectIOMerge == 0 {
+		return db.rateLimitedDataFile(dataFile), func() error { return nil }, nil
+	}
+	directFile, err := data.OpenDataFile(db.options.DirPath, dataFile.FileId, fio.DirectIO)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db.rateLimitedDataFile(directFile), directFile.Close, nil
+}
+
 // getMergePath 获取merge文件的路径
 
 // tmp/bitcask
@@ -195,14 +242,18 @@ func (db *DB) getMergePath() string {
 }
 
 // loadMergeFiles 加载merge文件
-func (db *DB) loadMergeFiles() error {
+func (db *DB) loadMergeFiles() (err error) {
 	mergePath := db.getMergePath()
 	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
 		return nil
 	}
 	defer func() {
-		if err := os.RemoveAll(mergePath); err != nil {
-			panic(err)
+		// keep the merge dir on failure, the next Open applies it again
+		if err != nil {
+			return
+		}
+		if removeErr := os.RemoveAll(mergePath); removeErr != nil {
+			err = removeErr
 		}
 	}()
 
@@ -223,52 +274,128 @@ func (db *DB) loadMergeFiles() error {
 		if entry.Name() == fileLockName {
 			continue
 		}
+		// the bptree index of the data dir is kept and updated below
+		if entry.Name() == index.BPTreeIndexFileName {
+			continue
+		}
 		mergeFileNames = append(mergeFileNames, entry.Name())
 	}
 
 	if !mergeFinished {
+		db.onRecovery(RecoveryInfo{Action: RecoveryMergeDiscarded})
 		return nil
 	}
 	nonMergeFileId, err := db.getNonMergeFileId(mergePath)
 	if err != nil {
 		return err
 	}
-	// delete id < nonMergeFileId
+	// the old files are replaced, drop their stats
 	var fileId uint32 = 0
 	for ; fileId < nonMergeFileId; fileId++ {
-		fileName := data.GetDataFileName(mergePath, fileId)
+		db.removeFileStats(fileId)
+	}
+
+	// bptree index is persistent and not rebuilt from hint file on open,
+	// it must point to the merged files before the old files are removed
+	if db.options.IndexType == BPTree {
+		if err := db.remapMergedIndex(mergePath, nonMergeFileId); err != nil {
+			return err
+		}
+	}
+
+	// delete id < nonMergeFileId
+	for fileId = 0; fileId < nonMergeFileId; fileId++ {
+		fileName := data.GetDataFileName(db.options.DirPath, fileId)
 		if _, err := os.Stat(fileName); err == nil {
 
 			if err := os.Remove(fileName); err != nil {
 				return err
 			}
 		}
+		// the merged file with the same id holds other records, drop the cached values
+		if db.valueCache != nil {
+			db.valueCache.RemoveFile(fileId)
+		}
 	}
 	// move new data file to data dir
-	fileId = 0
 	for _, fileName := range mergeFileNames {
-		if fileId < nonMergeFileId {
-			continue
-		}
 		srcPath := filepath.Join(mergePath, fileName)
 		destPath := filepath.Join(db.options.DirPath, fileName)
 		if err := os.Rename(srcPath, destPath); err != nil {
 			return err
 		}
 	}
+	db.onRecovery(RecoveryInfo{Action: RecoveryMergeApplied, FileId: nonMergeFileId})
 	return nil
 }
 
-func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
-	defer func() {
-		if err := mergeFinishedFile.Close(); err != nil {
-			panic(err)
+// remapMergedIndex points the bptree index to the merged files.
+// The remap is saved together with the file stats and the checkpoint in one transaction
+// without undo records, so a crash or a failed Open can't roll it back to the removed files.
+func (db *DB) remapMergedIndex(mergePath string, nonMergeFileId uint32) error {
+	bpt := db.index.(*index.BPlusTree)
+	cp, err := bpt.LoadCheckpoint()
+	if err != nil {
+		return err
+	}
+	if cp == nil {
+		cp = &index.Checkpoint{}
+	}
+
+	merged := make(map[string]*data.LogRecordPos)
+	if err := db.foldHintFile(mergePath, func(key []byte, pos *data.LogRecordPos) {
+		db.fileStats.addRecord(pos, data.LogRecordNormal)
+		merged[string(key)] = pos
+	}); err != nil {
+		return err
+	}
+
+	var ops []index.BatchOp
+	iterator := bpt.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		key, oldPos := iterator.Key(), iterator.Value()
+		pos, ok := merged[string(key)]
+		switch {
+		case oldPos.Fid < nonMergeFileId && ok:
+			ops = append(ops, index.BatchOp{Key: key, Pos: pos})
+		case oldPos.Fid < nonMergeFileId:
+			// the key was not live when the merge started
+			ops = append(ops, index.BatchOp{Key: key, Delete: true})
+		case ok:
+			// the key was rewritten after the merge started
+			db.markStale(pos)
 		}
-	}()
+		delete(merged, string(key))
+	}
+	iterator.Close()
+	if err := db.indexErr(); err != nil {
+		return err
+	}
+
+	// the rest keys are not in the index
+	for key, pos := range merged {
+		if cp.Fid < nonMergeFileId {
+			// written to the old files after the checkpoint, which can't be replayed any more
+			ops = append(ops, index.BatchOp{Key: []byte(key), Pos: pos})
+		} else {
+			// the key was deleted after the merge started
+			db.markStale(pos)
+		}
+	}
+	cp.Meta = db.fileStats.encode()
+	return bpt.ApplyWithCheckpoint(ops, cp)
+}
+
+func (db *DB) getNonMergeFileId(dirPath string) (fid uint32, err error) {
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
 	if err != nil {
 		return 0, err
 	}
+	defer func() {
+		if closeErr := mergeFinishedFile.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
 	record, _, err := mergeFinishedFile.ReadLogRecord(0)
 	if err != nil {
 		return 0, err
@@ -281,15 +408,40 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 }
 
 func (db *DB) loadIndexFromHintFile() error {
-	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
+	var ops []index.BatchOp
+	if err := db.foldHintFile(db.options.DirPath, func(key []byte, pos *data.LogRecordPos) {
+		db.fileStats.addRecord(pos, data.LogRecordNormal)
+		ops = db.appendIndexOp(ops, index.BatchOp{Key: key, Pos: pos})
+	}); err != nil {
+		return err
+	}
+	db.index.ApplyBatch(ops)
+	return db.indexErr()
+}
+
+// appendIndexOp 暂存一个索引操作，达到 indexBatchSize 时批量更新到索引中
+func (db *DB) appendIndexOp(ops []index.BatchOp, op index.BatchOp) []index.BatchOp {
+	ops = append(ops, op)
+	if len(ops) >= indexBatchSize {
+		db.index.ApplyBatch(ops)
+		return ops[:0]
+	}
+	return ops
+}
+
+// foldHintFile iterates all records of the hint file in dirPath
+func (db *DB) foldHintFile(dirPath string, fn func(key []byte, pos *data.LogRecordPos)) error {
+	hintFileName := filepath.Join(dirPath, data.HintFileName)
 	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
 		return nil
 	}
 
-	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	hintFile, err := data.OpenHintFile(dirPath)
 	if err != nil {
 		return err
 	}
+	defer hintFile.Close()
+	hintFile = db.rateLimitedDataFile(hintFile)
 
 	var offset int64 = 0
 	for {
@@ -301,7 +453,7 @@ func (db *DB) loadIndexFromHintFile() error {
 			return err
 		}
 		pos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
+		fn(logRecord.Key, pos)
 		offset += size
 	}
 	return nil
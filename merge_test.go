@@ -1,9 +1,13 @@
 package bitcaskgo
 
 import (
+	"bitcask-go/data"
 	"bitcask-go/utils"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -123,6 +127,11 @@ func TestDB_Merge4(t *testing.T) {
 	assert.Nil(t, err)
 	keys := db2.ListKey()
 	assert.Equal(t, 0, len(keys))
+
+	// 无效数据已经被清理
+	stat, err := db2.Stat()
+	assert.Nil(t, err)
+	assert.True(t, stat.DiskSize < 1024*1024)
 }
 
 // Merge 的过程中有新的数据写入或删除
@@ -173,6 +182,167 @@ func TestDB_Merge5(t *testing.T) {
 	}
 }
 
+// 使用 Direct I/O 读取待 merge 的数据文件
+func TestDB_Merge_DirectIO(t *testing.T) {
+	dir := "./tmp"
+	opts := DefaultOptions
+	opts.DataFileSize = 32 * 1024 * 1024
+	opts.DataFileMerGeRatio = 0
+	opts.DirPath = dir
+	opts.DirectIO = DirectIOMerge
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 50000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 10000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	keys := db2.ListKey()
+	assert.Equal(t, 40000, len(keys))
+}
+
+// 使用 B+ 树索引时 merge
+func TestDB_Merge_BPTree(t *testing.T) {
+	dir := "./tmp"
+	opts := DefaultOptions
+	opts.DataFileSize = 32 * 1024 * 1024
+	opts.DataFileMerGeRatio = 0
+	opts.DirPath = dir
+	opts.IndexType = BPTree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 50000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 10000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	// merge 之后写入的数据
+	for i := 40000; i < 50000; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("new value after merge"))
+		assert.Nil(t, err)
+	}
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	keys := db2.ListKey()
+	assert.Equal(t, 40000, len(keys))
+	for i := 10000; i < 40000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+	for i := 40000; i < 50000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("new value after merge"), val)
+	}
+}
+
+// 应用 merge 结果之后打开失败，B+ 树索引仍然指向 merge 之后的文件
+func TestDB_Merge_BPTreeOpenFailed(t *testing.T) {
+	opts := DefaultOptions
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMerGeRatio = 0
+	opts.IndexType = BPTree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	// merge 之后写入的数据
+	for i := 1500; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new value after merge")))
+	}
+	assert.Nil(t, db.Close())
+
+	// 加载数据文件失败，此时旧的数据文件和 merge 目录都已经被删除
+	badFile := filepath.Join(opts.DirPath, "abc"+data.DataFileNameSuffix)
+	assert.Nil(t, os.WriteFile(badFile, nil, 0644))
+	_, err = Open(opts)
+	assert.Equal(t, ErrDataDirectoryCorrupted, err)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, os.Remove(badFile))
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1500), mustStat(t, db).KeyNum)
+	for i := 0; i < 500; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	for i := 500; i < 1500; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	for i := 1500; i < 2000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("new value after merge"), val)
+	}
+}
+
+// merge 时限制读写速率
+func TestDB_Merge_RateLimit(t *testing.T) {
+	dir := "./tmp"
+	db, err := newTestMergeDB(dir)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+
+	// 读取和写入约 2MB 数据，每秒 8MB 至少需要 200ms
+	db.SetBackgroundIORate(8 * 1024 * 1024)
+	start := time.Now()
+	err = db.Merge()
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) >= 150*time.Millisecond)
+
+	// 取消限速后重启
+	db.SetBackgroundIORate(0)
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := newTestMergeDB(dir)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	keys := db2.ListKey()
+	assert.Equal(t, 1000, len(keys))
+}
+
 func newTestMergeDB(path string) (*DB, error) {
 	opts := DefaultOptions
 	opts.DataFileSize = 32 * 1024 * 1024
@@ -0,0 +1,153 @@
+package bitcaskgo
+
+import (
+	"bitcask-go/index"
+	"bitcask-go/metrics"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// engineMetrics 存储引擎运行时累计的指标
+type engineMetrics struct {
+	puts          metrics.Counter
+	gets          metrics.Counter
+	misses        metrics.Counter // 读取时 key 不存在的次数
+	deletes       metrics.Counter
+	batchCommits  metrics.Counter
+	bytesWritten  metrics.Counter
+	syncs         metrics.Counter
+	rotations     metrics.Counter
+	merges        metrics.Counter
+	reclaimed     metrics.Counter // merge 回收的字节数
+	corruptions   metrics.Counter
+	errors        metrics.Counter // 参数有效但执行失败的读写次数
+	writeLatency  *metrics.Histogram
+	readLatency   *metrics.Histogram
+	syncLatency   *metrics.Histogram
+	mergeDuration *metrics.Histogram
+}
+
+func newEngineMetrics() *engineMetrics {
+	return &engineMetrics{
+		writeLatency:  metrics.NewHistogram(metrics.DefaultLatencyBuckets),
+		readLatency:   metrics.NewHistogram(metrics.DefaultLatencyBuckets),
+		syncLatency:   metrics.NewHistogram(metrics.DefaultLatencyBuckets),
+		mergeDuration: metrics.NewHistogram([]float64{1, 5, 10, 30, 60, 300, 600, 1800, 3600}),
+	}
+}
+
+// observe 记录一次操作的耗时和结果，start 为操作开始的时间
+func (m *engineMetrics) observe(counter *metrics.Counter, latency *metrics.Histogram, start time.Time, err error) {
+	latency.Observe(time.Since(start))
+	m.count(counter, err)
+}
+
+// count 成功时增加操作的次数，key 不存在时增加未命中的次数，其他错误增加错误的次数
+func (m *engineMetrics) count(counter *metrics.Counter, err error) {
+	switch {
+	case err == nil:
+		counter.Inc()
+	case errors.Is(err, ErrKeyNotFound):
+		m.misses.Inc()
+	default:
+		m.errors.Inc()
+	}
+}
+
+// Metrics 存储引擎指标的快照
+type Metrics struct {
+	Puts         uint64 // 写入的 key 数量，包括批量写入
+	Gets         uint64 // 读取到的 key 数量
+	Misses       uint64 // 读取时 key 不存在的次数
+	Deletes      uint64 // 删除的 key 数量，包括批量写入
+	BatchCommits uint64 // 批量写入提交的次数
+	BytesWritten uint64 // 写入数据文件的字节数
+	Syncs        uint64 // 持久化活跃文件的次数
+	Rotations    uint64 // 切换活跃文件的次数
+	Merges       uint64 // 成功完成 merge 的次数
+	Reclaimed    uint64 // merge 回收的字节数
+	Corruptions  uint64 // 发现数据损坏的次数
+	Errors       uint64 // 参数有效但执行失败的读写次数，不计入上面的操作次数
+
+	WriteLatency  metrics.HistogramSnapshot // Put、Delete 和批量提交的耗时
+	ReadLatency   metrics.HistogramSnapshot // Get 的耗时
+	SyncLatency   metrics.HistogramSnapshot // 持久化的耗时
+	MergeDuration metrics.HistogramSnapshot // merge 的耗时
+
+	KeyNum          int   // 索引中 key 的数量
+	IndexMemSize    int64 // 内存索引占用的内存大小，不支持统计的索引类型为 0
+	DataFileNum     int   // 数据文件的数量
+	OpenFiles       int   // 当前打开的数据文件数量
+	ReclaimableSize int64 // 可回收的数据大小
+}
+
+// Metrics 返回存储引擎当前的指标
+func (db *DB) Metrics() *Metrics {
+	m := db.metrics
+	snapshot := &Metrics{
+		Puts:          m.puts.Load(),
+		Gets:          m.gets.Load(),
+		Misses:        m.misses.Load(),
+		Deletes:       m.deletes.Load(),
+		BatchCommits:  m.batchCommits.Load(),
+		BytesWritten:  m.bytesWritten.Load(),
+		Syncs:         m.syncs.Load(),
+		Rotations:     m.rotations.Load(),
+		Merges:        m.merges.Load(),
+		Reclaimed:     m.reclaimed.Load(),
+		Corruptions:   m.corruptions.Load(),
+		Errors:        m.errors.Load(),
+		WriteLatency:  m.writeLatency.Snapshot(),
+		ReadLatency:   m.readLatency.Snapshot(),
+		SyncLatency:   m.syncLatency.Snapshot(),
+		MergeDuration: m.mergeDuration.Snapshot(),
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	snapshot.KeyNum = db.index.Size()
+	if sizer, ok := db.index.(index.MemSizer); ok {
+		snapshot.IndexMemSize = sizer.MemSize()
+	}
+	snapshot.DataFileNum = len(db.olderFiles)
+	if db.fileCache != nil {
+		snapshot.OpenFiles = db.fileCache.OpenFiles()
+	} else {
+		snapshot.OpenFiles = len(db.olderFiles)
+	}
+	if db.activeFile != nil {
+		snapshot.DataFileNum++
+		snapshot.OpenFiles++
+	}
+	snapshot.ReclaimableSize = atomic.LoadInt64(&db.reclaimSize)
+	return snapshot
+}
+
+// WritePrometheus 按照 Prometheus 文本格式输出指标
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	pw := metrics.NewWriter(w)
+	pw.Counter("bitcask_puts_total", "Number of keys written.", m.Puts)
+	pw.Counter("bitcask_gets_total", "Number of keys read.", m.Gets)
+	pw.Counter("bitcask_get_misses_total", "Number of reads of missing keys.", m.Misses)
+	pw.Counter("bitcask_deletes_total", "Number of keys deleted.", m.Deletes)
+	pw.Counter("bitcask_batch_commits_total", "Number of committed write batches.", m.BatchCommits)
+	pw.Counter("bitcask_written_bytes_total", "Bytes appended to data files.", m.BytesWritten)
+	pw.Counter("bitcask_syncs_total", "Number of active file syncs.", m.Syncs)
+	pw.Counter("bitcask_file_rotations_total", "Number of active file rotations.", m.Rotations)
+	pw.Counter("bitcask_merges_total", "Number of completed merges.", m.Merges)
+	pw.Counter("bitcask_merge_reclaimed_bytes_total", "Bytes reclaimed by merges.", m.Reclaimed)
+	pw.Counter("bitcask_corruptions_total", "Number of corrupted records detected.", m.Corruptions)
+	pw.Counter("bitcask_errors_total", "Number of failed reads and writes.", m.Errors)
+	pw.Histogram("bitcask_write_duration_seconds", "Latency of puts, deletes and batch commits.", m.WriteLatency)
+	pw.Histogram("bitcask_read_duration_seconds", "Latency of gets.", m.ReadLatency)
+	pw.Histogram("bitcask_sync_duration_seconds", "Latency of active file syncs.", m.SyncLatency)
+	pw.Histogram("bitcask_merge_duration_seconds", "Duration of completed merges.", m.MergeDuration)
+	pw.Gauge("bitcask_keys", "Number of keys in the index.", float64(m.KeyNum))
+	pw.Gauge("bitcask_index_memory_bytes", "Memory used by the in-memory index.", float64(m.IndexMemSize))
+	pw.Gauge("bitcask_data_files", "Number of data files.", float64(m.DataFileNum))
+	pw.Gauge("bitcask_open_files", "Number of open data files.", float64(m.OpenFiles))
+	pw.Gauge("bitcask_reclaimable_bytes", "Bytes of stale data that a merge can reclaim.", float64(m.ReclaimableSize))
+	return pw.Err()
+}
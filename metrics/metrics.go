@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencyBuckets 延迟直方图默认的桶上界，单位为秒，从 10us 到 10s
+var DefaultLatencyBuckets = []float64{
+	0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10,
+}
+
+// Counter 单调递增的计数器，可以并发使用
+type Counter struct {
+	value atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) Load() uint64 {
+	return c.value.Load()
+}
+
+// Histogram 固定桶的耗时直方图，可以并发使用
+type Histogram struct {
+	bounds []float64       // 每个桶的上界，单位为秒，从小到大排列
+	counts []atomic.Uint64 // 每个桶内的观测次数，最后一个桶没有上界
+	sum    atomic.Int64    // 所有观测值的和，单位为纳秒
+}
+
+// NewHistogram 初始化直方图，bounds 为每个桶的上界，单位为秒
+func NewHistogram(bounds []float64) *Histogram {
+	bounds = append([]float64(nil), bounds...)
+	sort.Float64s(bounds)
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]atomic.Uint64, len(bounds)+1),
+	}
+}
+
+// Observe 记录一次耗时
+func (h *Histogram) Observe(d time.Duration) {
+	i := sort.SearchFloat64s(h.bounds, d.Seconds())
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// Snapshot 返回直方图当前的快照
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	snapshot := HistogramSnapshot{
+		Bounds: h.bounds,
+		Counts: make([]uint64, len(h.bounds)),
+	}
+	for i := range h.bounds {
+		snapshot.Count += h.counts[i].Load()
+		snapshot.Counts[i] = snapshot.Count
+	}
+	snapshot.Count += h.counts[len(h.bounds)].Load()
+	snapshot.Sum = time.Duration(h.sum.Load()).Seconds()
+	return snapshot
+}
+
+// HistogramSnapshot 直方图的快照
+type HistogramSnapshot struct {
+	Bounds []float64 // 每个桶的上界，单位为秒
+	Counts []uint64  // 小于等于对应上界的观测次数，是累计值
+	Count  uint64    // 总的观测次数
+	Sum    float64   // 所有观测值的和，单位为秒
+}
+
+// Writer 按照 Prometheus 文本格式输出指标，遇到第一个错误之后不再写入
+type Writer struct {
+	w   io.Writer
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Err 返回写入过程中遇到的第一个错误
+func (pw *Writer) Err() error {
+	return pw.err
+}
+
+// Counter 输出一个计数器
+func (pw *Writer) Counter(name, help string, value uint64) {
+	pw.header(name, help, "counter")
+	pw.printf("%s %d\n", name, value)
+}
+
+// Gauge 输出一个瞬时值
+func (pw *Writer) Gauge(name, help string, value float64) {
+	pw.header(name, help, "gauge")
+	pw.printf("%s %s\n", name, formatFloat(value))
+}
+
+// Histogram 输出一个直方图
+func (pw *Writer) Histogram(name, help string, h HistogramSnapshot) {
+	pw.header(name, help, "histogram")
+	for i, bound := range h.Bounds {
+		pw.printf("%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), h.Counts[i])
+	}
+	pw.printf("%s_bucket{le=\"+Inf\"} %d\n", name, h.Count)
+	pw.printf("%s_sum %s\n", name, formatFloat(h.Sum))
+	pw.printf("%s_count %d\n", name, h.Count)
+}
+
+func (pw *Writer) header(name, help, typ string) {
+	pw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (pw *Writer) printf(format string, args ...any) {
+	if pw.err != nil {
+		return
+	}
+	_, pw.err = fmt.Fprintf(pw.w, format, args...)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram_Snapshot(t *testing.T) {
+	h := NewHistogram([]float64{0.01, 0.001, 0.1})
+	h.Observe(500 * time.Microsecond)
+	h.Observe(time.Millisecond)
+	h.Observe(50 * time.Millisecond)
+	h.Observe(time.Second)
+
+	snapshot := h.Snapshot()
+	assert.Equal(t, []float64{0.001, 0.01, 0.1}, snapshot.Bounds)
+	assert.Equal(t, []uint64{2, 2, 3}, snapshot.Counts)
+	assert.Equal(t, uint64(4), snapshot.Count)
+	assert.InDelta(t, 1.0515, snapshot.Sum, 1e-9)
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHistogram([]float64{0.001})
+	h.Observe(2 * time.Millisecond)
+
+	w := NewWriter(&buf)
+	w.Counter("test_total", "Total count.", 3)
+	w.Gauge("test_size", "Current size.", 1.5)
+	w.Histogram("test_seconds", "Latency.", h.Snapshot())
+	assert.Nil(t, w.Err())
+
+	expected := `# HELP test_total Total count.
+# TYPE test_total counter
+test_total 3
+# HELP test_size Current size.
+# TYPE test_size gauge
+test_size 1.5
+# HELP test_seconds Latency.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.001"} 0
+test_seconds_bucket{le="+Inf"} 1
+test_seconds_sum 0.002
+test_seconds_count 1
+`
+	assert.Equal(t, expected, buf.String())
+}
@@ -0,0 +1,111 @@
+package bitcaskgo
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Metrics(t *testing.T) {
+	opts := DefaultOptions
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMerGeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(1000), utils.RandomValue(10)))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(999)))
+	assert.Nil(t, wb.Commit())
+	_, err = db.Get(utils.GetTestKey(998))
+	assert.Nil(t, err)
+
+	m := db.Metrics()
+	assert.Equal(t, uint64(1001), m.Puts)
+	assert.Equal(t, uint64(501), m.Deletes)
+	assert.Equal(t, uint64(1), m.Gets)
+	assert.Equal(t, uint64(1), m.BatchCommits)
+	assert.Equal(t, uint64(1501), m.WriteLatency.Count)
+	assert.Equal(t, uint64(1), m.ReadLatency.Count)
+	assert.Greater(t, m.BytesWritten, uint64(1000*128))
+	assert.Greater(t, m.Rotations, uint64(0))
+	assert.Equal(t, m.Syncs, m.SyncLatency.Count)
+	assert.Equal(t, 500, m.KeyNum)
+	assert.Equal(t, int(m.Rotations)+1, m.DataFileNum)
+	assert.Equal(t, m.DataFileNum, m.OpenFiles)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	defer os.RemoveAll(db.getMergePath())
+	m = db.Metrics()
+	assert.Equal(t, uint64(1), m.Merges)
+	assert.Equal(t, uint64(1), m.MergeDuration.Count)
+	assert.Greater(t, m.Reclaimed, uint64(0))
+
+	var buf bytes.Buffer
+	err = m.WritePrometheus(&buf)
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), "bitcask_puts_total 1001\n")
+	assert.Contains(t, buf.String(), "# TYPE bitcask_sync_duration_seconds histogram\n")
+	assert.Contains(t, buf.String(), "bitcask_keys 500\n")
+}
+
+func TestDB_MetricsFailed(t *testing.T) {
+	opts := DefaultOptions
+	opts.IndexType = BPTree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(0), utils.RandomValue(10)))
+
+	// 参数无效时不计数
+	assert.Equal(t, ErrKeyIsEmpty, db.Put(nil, utils.RandomValue(10)))
+	_, err = db.Get(nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+	// key 不存在时计入未命中
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, errs := db.MultiGet([][]byte{utils.GetTestKey(0), utils.GetTestKey(1), nil})
+	assert.Nil(t, errs[0])
+
+	m := db.Metrics()
+	assert.Equal(t, uint64(1), m.Puts)
+	assert.Equal(t, uint64(1), m.Gets)
+	assert.Equal(t, uint64(2), m.Misses)
+	assert.Equal(t, uint64(0), m.Errors)
+	assert.Equal(t, uint64(1), m.WriteLatency.Count)
+	assert.Equal(t, uint64(1), m.ReadLatency.Count)
+
+	// 关闭 bbolt 模拟索引失败，失败的写入和读取只计入错误
+	assert.Nil(t, db.index.Close())
+	assert.ErrorIs(t, db.Put(utils.GetTestKey(2), utils.RandomValue(10)), ErrIndexFailed)
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.ErrorIs(t, err, ErrIndexFailed)
+
+	m = db.Metrics()
+	assert.Equal(t, uint64(1), m.Puts)
+	assert.Equal(t, uint64(1), m.Gets)
+	assert.Equal(t, uint64(2), m.Errors)
+	assert.Equal(t, uint64(2), m.WriteLatency.Count)
+
+	var buf bytes.Buffer
+	assert.Nil(t, m.WritePrometheus(&buf))
+	assert.Contains(t, buf.String(), "bitcask_errors_total 2\n")
+	assert.Contains(t, buf.String(), "bitcask_get_misses_total 2\n")
+	assert.NotNil(t, db.Close())
+
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+}
@@ -1,5 +1,7 @@
 package bitcaskgo
 
+import "log/slog"
+
 type Options struct {
 	DirPath string // 数据库数据目录
 
@@ -14,6 +16,26 @@ type Options struct {
 	MMapAtStartup bool // 启动时是否使用 mmap 加载数据
 
 	DataFileMerGeRatio float32 // 数据文件合并的阈值
+
+	PreallocateDataFile bool // 是否为活跃数据文件预分配 DataFileSize 大小的磁盘空间，仅在 Linux 下生效
+
+	DirectIO DirectIOMode // 使用 Direct I/O 读取数据文件的场景，仅在 Linux 下生效
+
+	MaxOpenFiles int // 旧数据文件最多同时打开的文件数，0 表示不限制并在启动时打开所有文件
+
+	CacheSize int64 // value 读缓存占用内存的上限，以字节为单位，0 表示不开启缓存
+
+	BackgroundIORate int64 // merge、备份和重建索引等后台任务每秒读写的字节数，0 表示不限速
+
+	BloomFilterFPRate float64 // BPTree 索引布隆过滤器的误判率，0 表示不开启，查询不存在的 key 时可以不访问磁盘
+
+	// Comparator 索引和迭代器中 key 的排列顺序，为空时按照字节序排列。
+	// 对不同的 key 返回 0 时按照字节序排列这些 key，ART 和 BPTree 索引不支持自定义顺序
+	Comparator func(a, b []byte) int
+
+	Logger *slog.Logger // 结构化日志，为空时不输出日志
+
+	EventListener EventListener // 文件切换、merge、持久化、恢复和数据损坏等内部事件的回调，为空时忽略
 }
 
 // 迭代器选项
@@ -21,6 +43,12 @@ type IteratorOptions struct {
 	Prefix []byte // 遍历前缀为指定值的 Key，默认为空
 
 	Reverse bool // 是否反向遍历，默认 false 是正向
+
+	LowerBound []byte // 遍历范围的下界，包含此 key，为空时不限制
+
+	UpperBound []byte // 遍历范围的上界，不包含此 key，为空时不限制
+
+	PrefetchSize int // 预读后续多少个 key 的 value，0 表示不预读，适合需要读取大量 value 的遍历
 }
 
 // 批量写配置选项
@@ -41,21 +69,58 @@ const (
 
 	// BPTree B+树索引
 	BPTree
+
+	// Hash 分片哈希索引，点查询更快、内存占用更少，迭代时需要对所有 key 排序
+	Hash
+
+	// ShardedBTree 分片 BTree 索引，多核并发写入时锁竞争更少
+	ShardedBTree
+
+	// Compact 内存紧凑的索引，key 集中存储、位置信息内联在索引项中，适合 key 数量巨大的场景
+	Compact
+
+	// SkipList 并发跳表索引，读取不加锁，迭代器读取快照而不需要拷贝所有数据
+	SkipList
+)
+
+// DirectIOMode 使用 Direct I/O 的场景，可以按位组合
+type DirectIOMode = uint8
+
+const (
+	// DirectIOMerge merge 时读取旧的数据文件
+	DirectIOMerge DirectIOMode = 1 << iota
+
+	// DirectIOBackup 备份时读取数据目录中的文件
+	DirectIOBackup
+
+	// DirectIOAllReads 所有数据文件的读取，包括 Get 和迭代器
+	DirectIOAllReads
 )
 
 var DefaultOptions = Options{
-	DirPath:            "./tmp",
-	DataFileSize:       256 * 1024 * 1024, // 256MB
-	SyncWrites:         false,
-	BytesPerSync:       0,
-	IndexType:          BTree,
-	MMapAtStartup:      true,
-	DataFileMerGeRatio: 0.5,
+	DirPath:             "./tmp",
+	DataFileSize:        256 * 1024 * 1024, // 256MB
+	SyncWrites:          false,
+	BytesPerSync:        0,
+	IndexType:           BTree,
+	MMapAtStartup:       true,
+	DataFileMerGeRatio:  0.5,
+	PreallocateDataFile: false,
+	DirectIO:            0,
+	MaxOpenFiles:        0,
+	CacheSize:           0,
+	BackgroundIORate:    0,
+	BloomFilterFPRate:   0,
+	Logger:              nil,
+	EventListener:       nil,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
-	Prefix:  nil,
-	Reverse: false,
+	Prefix:       nil,
+	Reverse:      false,
+	LowerBound:   nil,
+	UpperBound:   nil,
+	PrefetchSize: 0,
 }
 
 var DefaultWriteBatchOptions = WriteBatchOptions{
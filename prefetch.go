@@ -0,0 +1,129 @@
+package bitcaskgo
+
+import (
+	"bitcask-go/data"
+	"sort"
+	"sync"
+)
+
+const (
+	// 合并相邻数据之后单次读取的最大字节数
+	prefetchMaxReadSize = 1 << 20
+
+	// 并行读取的最大数量
+	prefetchConcurrency = 4
+
+	// Fold 每批预读的 value 数量
+	foldPrefetchSize = 256
+)
+
+// prefetchRead 一次读取，包含数据文件中若干条首尾相接的数据
+type prefetchRead struct {
+	fid     uint32
+	offset  int64
+	size    int64
+	indexes []int // 每条数据在 positions 中的下标
+}
+
+// readValues 批量读取多个位置上的 value，返回的 value 和错误与 positions 一一对应
+// 需要读取的数据按照 (Fid, Offset) 排序，首尾相接的数据合并成一次读取，多次读取之间并行执行。
+// 在访问此方法前必须持有读锁
+func (db *DB) readValues(positions []*data.LogRecordPos) ([][]byte, []error) {
+	values := make([][]byte, len(positions))
+	errs := make([]error, len(positions))
+
+	var pending []int
+	for i, pos := range positions {
+		if db.valueCache != nil {
+			if value, ok := db.valueCache.Get(pos); ok {
+				values[i] = value
+				continue
+			}
+		}
+		pending = append(pending, i)
+	}
+	sort.Slice(pending, func(a, b int) bool {
+		pa, pb := positions[pending[a]], positions[pending[b]]
+		if pa.Fid != pb.Fid {
+			return pa.Fid < pb.Fid
+		}
+		return pa.Offset < pb.Offset
+	})
+
+	var reads []*prefetchRead
+	for _, i := range pending {
+		pos := positions[i]
+		if n := len(reads); n > 0 {
+			last := reads[n-1]
+			if last.fid == pos.Fid && last.offset+last.size == pos.Offset &&
+				last.size+int64(pos.Size) <= prefetchMaxReadSize {
+				last.size += int64(pos.Size)
+				last.indexes = append(last.indexes, i)
+				continue
+			}
+		}
+		reads = append(reads, &prefetchRead{
+			fid:     pos.Fid,
+			offset:  pos.Offset,
+			size:    int64(pos.Size),
+			indexes: []int{i},
+		})
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, prefetchConcurrency)
+	for _, read := range reads {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(read *prefetchRead) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			db.doPrefetchRead(read, positions, values, errs)
+		}(read)
+	}
+	wg.Wait()
+	return values, errs
+}
+
+func (db *DB) doPrefetchRead(read *prefetchRead, positions []*data.LogRecordPos, values [][]byte, errs []error) {
+	setErr := func(err error) {
+		for _, i := range read.indexes {
+			errs[i] = err
+		}
+	}
+
+	var dataFile *data.DataFile
+	if db.activeFile != nil && db.activeFile.FileId == read.fid {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.olderFiles[read.fid]
+	}
+	if dataFile == nil {
+		setErr(ErrDataFileNotFound)
+		return
+	}
+
+	logRecords, err := dataFile.ReadLogRecords(read.offset, read.size)
+	if err != nil {
+		db.reportCorruption(read.fid, read.offset, err)
+		setErr(err)
+		return
+	}
+	if len(logRecords) != len(read.indexes) {
+		setErr(ErrDataDirectoryCorrupted)
+		return
+	}
+	for j, i := range read.indexes {
+		logRecord := logRecords[j]
+		if logRecord.Type == data.LogRecordDeleted {
+			errs[i] = ErrKeyNotFound
+			continue
+		}
+		values[i] = logRecord.Value
+		if db.valueCache != nil {
+			db.valueCache.Put(positions[i], logRecord.Value)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package bitcaskgo
+
+import (
+	"encoding/base64"
+)
+
+// 游标编码的版本号，以后修改游标格式时可以兼容旧的游标
+const scanCursorVersion byte = 1
+
+// Scan 分页遍历数据，从 cursor 之后开始最多返回 limit 条数据
+// cursor 为空表示从头开始，返回的 nextCursor 为空表示已经遍历完成。
+// 游标中记录的是上一页的最后一个 key，两页之间有写入时也会从这个 key 之后继续遍历
+func (db *DB) Scan(cursor string, limit int, opts IteratorOptions) (keys [][]byte, values [][]byte, nextCursor string, err error) {
+	return db.scan(cursor, limit, opts, true)
+}
+
+// ScanKeys 与 Scan 相同，但只返回 key，不需要读取数据文件
+func (db *DB) ScanKeys(cursor string, limit int, opts IteratorOptions) (keys [][]byte, nextCursor string, err error) {
+	keys, _, nextCursor, err = db.scan(cursor, limit, opts, false)
+	return keys, nextCursor, err
+}
+
+func (db *DB) scan(cursor string, limit int, opts IteratorOptions, withValues bool) ([][]byte, [][]byte, string, error) {
+	if limit <= 0 {
+		return nil, nil, "", ErrInvalidScanLimit
+	}
+	lastKey, err := decodeScanCursor(cursor)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	iterator := db.NewIterator(opts)
+	defer iterator.Close()
+	if lastKey != nil {
+		iterator.Seek(lastKey)
+		if iterator.Valid() && db.compareKeys(iterator.Key(), lastKey) == 0 {
+			iterator.Next()
+		}
+	}
+
+	var keys, values [][]byte
+	for ; iterator.Valid() && len(keys) < limit; iterator.Next() {
+		if withValues {
+			value, err := iterator.Value()
+			if err != nil {
+				return nil, nil, "", err
+			}
+			values = append(values, value)
+		}
+		keys = append(keys, iterator.Key())
+	}
+	if !iterator.Valid() {
+		return keys, values, "", nil
+	}
+	return keys, values, encodeScanCursor(keys[len(keys)-1]), nil
+}
+
+func encodeScanCursor(key []byte) string {
+	buf := make([]byte, 0, len(key)+1)
+	buf = append(buf, scanCursorVersion)
+	buf = append(buf, key...)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeScanCursor(cursor string) ([]byte, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(buf) < 2 || buf[0] != scanCursorVersion {
+		return nil, ErrInvalidScanCursor
+	}
+	return buf[1:], nil
+}
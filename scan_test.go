@@ -0,0 +1,56 @@
+package bitcaskgo
+
+import (
+	"bitcask-go/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Scan(t *testing.T) {
+	opts := DefaultOptions
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 25; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+
+	var keys [][]byte
+	var cursor string
+	for page := 0; ; page++ {
+		pageKeys, values, next, err := db.Scan(cursor, 10, DefaultIteratorOptions)
+		assert.Nil(t, err)
+		assert.Equal(t, pageKeys, values)
+		keys = append(keys, pageKeys...)
+		if page == 0 {
+			// 两页之间的写入不影响后续的遍历
+			assert.Nil(t, db.Delete(utils.GetTestKey(5)))
+			assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(10)))
+		}
+		if next == "" {
+			assert.Equal(t, 2, page)
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, 25, len(keys))
+	for i := 1; i < len(keys); i++ {
+		assert.Less(t, string(keys[i-1]), string(keys[i]))
+	}
+
+	// 反向遍历
+	keys, next, err := db.ScanKeys("", 20, IteratorOptions{Reverse: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 20, len(keys))
+	keys, next, err = db.ScanKeys(next, 20, IteratorOptions{Reverse: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(keys))
+	assert.Equal(t, "", next)
+
+	_, _, err = db.ScanKeys("not-a-cursor!", 10, DefaultIteratorOptions)
+	assert.Equal(t, ErrInvalidScanCursor, err)
+	_, _, err = db.ScanKeys("", 0, DefaultIteratorOptions)
+	assert.Equal(t, ErrInvalidScanLimit, err)
+}
@@ -3,9 +3,6 @@ package utils
 import (
 	"os"
 	"path/filepath"
-	"strings"
-	"syscall"
-	"unsafe"
 )
 
 // DirSize 获取目录大小
@@ -26,38 +23,13 @@ func DirSize(dirPath string) (int64, error) {
 	return size, err
 }
 
-// 取指定目录所在磁盘的剩余空间大小
-func AvailableDiskSize(dirPath string) (uint64, error) {
-	// 加载 kernel32.dll
-	kernel32 := syscall.NewLazyDLL("kernel32.dll")
-	// 获取 GetDiskFreeSpaceExW 函数
-	procGetDiskFreeSpaceExW := kernel32.NewProc("GetDiskFreeSpaceExW")
-
-	// 将路径转换为 UTF-16
-	pathPtr, err := syscall.UTF16PtrFromString(dirPath)
-	if err != nil {
-		return 0, err
-	}
-
-	var freeBytesAvailable, _, _ int64
-
-	// 调用 GetDiskFreeSpaceExW
-	ret, _, err := procGetDiskFreeSpaceExW.Call(
-		uintptr(unsafe.Pointer(pathPtr)),
-		uintptr(unsafe.Pointer(&freeBytesAvailable)),
-		0,
-		0,
-	)
-
-	if ret == 0 {
-		return 0, err
-	}
-
-	return uint64(freeBytesAvailable), nil
-}
-
 // 拷贝数据目录
 func CopyDir(src, dest string, exclude []string) error {
+	return CopyDirWithReader(src, dest, exclude, os.ReadFile)
+}
+
+// CopyDirWithReader 拷贝数据目录，使用 readFile 读取源文件的内容
+func CopyDirWithReader(src, dest string, exclude []string, readFile func(name string) ([]byte, error)) error {
 	// 目标文件夹不存在则创建
 	if _, err := os.Stat(dest); os.IsNotExist(err) {
 		if err := os.MkdirAll(dest, os.ModePerm); err != nil {
@@ -66,8 +38,14 @@ func CopyDir(src, dest string, exclude []string) error {
 	}
 
 	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-		fileName := strings.Replace(path, src, "", 1)
-		if fileName == "" {
+		if err != nil {
+			return err
+		}
+		fileName, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if fileName == "." {
 			return nil
 		}
 		for _, e := range exclude {
@@ -85,10 +63,10 @@ func CopyDir(src, dest string, exclude []string) error {
 			}
 			return nil
 		}
-		data, errRD := os.ReadFile(fileName)
+		data, errRD := readFile(path)
 		if errRD != nil {
 			return errRD
 		}
-		return os.WriteFile(fileName, data, info.Mode())
+		return os.WriteFile(filepath.Join(dest, fileName), data, info.Mode())
 	})
 }
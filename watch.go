@@ -0,0 +1,610 @@
+package bitcaskgo
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 变更订阅
+// 每次写入在持有互斥锁时分配一个发布序号，更新完索引之后按照序号的顺序发布，
+// 因此订阅者收到的事件和数据文件中的写入顺序一致。没有订阅者时写入不分配序号，只记录还没有更新完索引的写入的数量，
+// 之后订阅时分配一个序号标记这些写入的结束位置，它们都更新完索引之后才发布，订阅者再从数据文件中读取这期间的写入。
+// 已经发布的位置之前的写入都已经更新完索引，从数据文件中追赶时不会读取这个位置之后的数据。
+// 每个订阅者有一个投递协程和有上限的事件队列，消费太慢导致队列写满时丢弃队列中的事件，
+// 改为从数据文件中读取已经发布的写入，追上之后再继续接收新的事件，写入者不会被阻塞，事件也不会丢失
+
+const (
+	// 订阅者 channel 的缓冲大小
+	watchChanSize = 256
+
+	// 每个订阅者最多暂存的事件数量，超过之后改为从数据文件中追赶
+	watchMaxPending = 4096
+
+	// 从数据文件中追赶时，每次持有读锁读取的数据条数
+	watchTailBatch = 256
+
+	// 没有订阅者时写入得到的发布序号，不需要发布
+	noTicket = ^uint64(0)
+)
+
+// EventType 变更事件的类型
+type EventType byte
+
+const (
+	// EventPut 写入数据
+	EventPut EventType = iota + 1
+
+	// EventDelete 删除数据
+	EventDelete
+)
+
+// Event key 的一次变更
+type Event struct {
+	Type      EventType
+	Key       []byte
+	Value     []byte    // 删除时为空
+	Seq       uint64    // 变更之后在数据文件中的位置，传给 WatchFrom 可以从这次变更之后继续订阅
+	Timestamp time.Time // 写入时间，没有记录写入时间的旧数据为零值
+}
+
+// Watch 订阅前缀为 prefix 的 key 从现在开始的变更，prefix 为空时订阅所有 key
+// 同一个 WriteBatch 中的变更拥有相同的 Seq，并且连续投递。
+// 调用返回的 cancel 取消订阅，之后 channel 会被关闭
+func (db *DB) Watch(prefix []byte) (<-chan Event, func()) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.watchHub.register(db, prefix, db.currentSeq(), false)
+}
+
+// WatchFrom 订阅前缀为 prefix 的 key 从 seq 之后的变更，先从数据文件中读取已经写入的变更，再继续接收新的变更
+// seq 为 0 时从第一个数据文件开始，通常传入上一次收到的最后一个事件的 Seq，位于事务中间时从事务的第一条数据开始读取。
+// merge 之后旧数据文件中的位置会失效，从这些位置继续订阅可能会收到重复的事件
+func (db *DB) WatchFrom(prefix []byte, seq uint64) (<-chan Event, func()) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.watchHub.register(db, prefix, seq, true)
+}
+
+// encodeSeq 将数据文件中的位置编码为事件的序号，数据文件的大小不超过 4GB
+func encodeSeq(fid uint32, offset int64) uint64 {
+	return uint64(fid)<<32 | uint64(offset)
+}
+
+func decodeSeq(seq uint64) (uint32, int64) {
+	return uint32(seq >> 32), int64(seq & 0xffffffff)
+}
+
+// endSeq 数据之后的位置对应的序号
+func endSeq(pos *data.LogRecordPos) uint64 {
+	return encodeSeq(pos.Fid, pos.Offset+int64(pos.Size))
+}
+
+// currentSeq 活跃文件末尾对应的序号，在访问此方法前必须持有互斥锁
+func (db *DB) currentSeq() uint64 {
+	if db.activeFile == nil {
+		return 0
+	}
+	return encodeSeq(db.activeFile.FileId, db.activeFile.WriteOff)
+}
+
+func eventTime(timestamp int64) time.Time {
+	if timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, timestamp)
+}
+
+// publishWrite 发布一次 Put 或 Delete
+func (db *DB) publishWrite(ticket uint64, pos *data.LogRecordPos, typ EventType, key, value []byte) {
+	var events []Event
+	if ticket != noTicket && db.watchHub.watching() {
+		events = []Event{{Type: typ, Key: key, Value: value, Timestamp: eventTime(pos.Timestamp)}}
+	}
+	db.watchHub.publish(ticket, endSeq(pos), events)
+}
+
+// watchHub 按照写入顺序发布变更，管理所有的订阅者
+type watchHub struct {
+	nextTicket uint64       // 下一个分配的发布序号，在持有 db.mu 时分配
+	numWatcher atomic.Int32 // 在持有 db.mu 时增加，因此分配序号时可以据此判断是否需要发布
+	untracked  atomic.Int64 // 没有分配序号并且还没有更新完索引的写入的数量
+	waiting    atomic.Bool  // 是否有等待 untracked 变为 0 的写入间隔
+
+	mu            sync.Mutex
+	publishTicket uint64                 // 下一个应该发布的序号
+	pending       map[uint64]*watchGroup // 已经完成但还没有轮到发布的写入
+	lastSeq       uint64                 // 最后一次发布的写入之后的位置
+	gaps          map[uint64]*watchGroup // 等待没有分配序号的写入更新完索引的间隔
+	watchers      map[*watcher]struct{}
+}
+
+// watchGroup 一次原子写入产生的变更
+type watchGroup struct {
+	seq    uint64
+	events []Event
+	gap    bool // 之前有没有发布的写入，订阅者需要从数据文件中读取
+}
+
+func newWatchHub(seq uint64) *watchHub {
+	return &watchHub{
+		pending:  make(map[uint64]*watchGroup),
+		lastSeq:  seq,
+		gaps:     make(map[uint64]*watchGroup),
+		watchers: make(map[*watcher]struct{}),
+	}
+}
+
+func (h *watchHub) watching() bool {
+	return h.numWatcher.Load() > 0
+}
+
+// reserve 写入数据文件成功之后分配发布序号，在访问此方法前必须持有 db.mu
+// 分配的序号必须调用 publish 发布，否则之后的写入都无法发布，没有订阅者时返回 noTicket
+func (h *watchHub) reserve() uint64 {
+	if !h.watching() {
+		h.untracked.Add(1)
+		return noTicket
+	}
+	ticket := h.nextTicket
+	h.nextTicket++
+	return ticket
+}
+
+// publish 更新完索引之后发布变更，轮到这个序号时投递给订阅者
+func (h *watchHub) publish(ticket uint64, seq uint64, events []Event) {
+	if ticket == noTicket {
+		// 最后一个没有分配序号的写入更新完索引之后，发布等待它们的间隔
+		if h.untracked.Add(-1) == 0 && h.waiting.Load() {
+			h.mu.Lock()
+			h.releaseGaps()
+			h.mu.Unlock()
+		}
+		return
+	}
+	for i := range events {
+		events[i].Seq = seq
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.put(ticket, &watchGroup{seq: seq, events: events})
+}
+
+// put 轮到这个序号时投递，否则暂存起来，需要持有 h.mu
+func (h *watchHub) put(ticket uint64, group *watchGroup) {
+	if ticket != h.publishTicket {
+		h.pending[ticket] = group
+		return
+	}
+	h.dispatch(group)
+	for {
+		group, ok := h.pending[h.publishTicket]
+		if !ok {
+			return
+		}
+		delete(h.pending, h.publishTicket)
+		h.dispatch(group)
+	}
+}
+
+// dispatch 需要持有 h.mu
+func (h *watchHub) dispatch(group *watchGroup) {
+	h.publishTicket++
+	// 订阅时可能已经跳过了之后的位置
+	if group.seq > h.lastSeq {
+		h.lastSeq = group.seq
+	}
+	for w := range h.watchers {
+		w.offer(group)
+	}
+}
+
+// releaseGaps 没有分配序号的写入都更新完索引之后发布所有的间隔，需要持有 h.mu
+func (h *watchHub) releaseGaps() {
+	if h.untracked.Load() != 0 {
+		return
+	}
+	h.waiting.Store(false)
+	for ticket, group := range h.gaps {
+		delete(h.gaps, ticket)
+		h.put(ticket, group)
+	}
+}
+
+// register 添加订阅者，在访问此方法前必须持有 db.mu
+func (h *watchHub) register(db *DB, prefix []byte, seq uint64, lagging bool) (<-chan Event, func()) {
+	w := &watcher{
+		db:      db,
+		hub:     h,
+		prefix:  prefix,
+		ch:      make(chan Event, watchChanSize),
+		done:    make(chan struct{}),
+		notify:  make(chan struct{}, 1),
+		queued:  seq,
+		lagging: lagging,
+		align:   lagging,
+		sent:    seq,
+	}
+	h.mu.Lock()
+	// 没有订阅者时的写入不会发布，分配一个序号标记它们的结束位置，这些写入都更新完索引之后才发布，
+	// 在此之前已经发布的位置不会越过还没有更新完索引的写入
+	if current := db.currentSeq(); current > h.lastSeq {
+		ticket := h.nextTicket
+		h.nextTicket++
+		h.gaps[ticket] = &watchGroup{seq: current, gap: true}
+		// 先标记再检查数量，和写入者减少数量之后再检查标记配合，不会错过发布
+		h.waiting.Store(true)
+		h.releaseGaps()
+	}
+	h.watchers[w] = struct{}{}
+	h.numWatcher.Add(1)
+	h.mu.Unlock()
+	go w.run()
+	return w.ch, w.cancel
+}
+
+// closeAll 关闭数据库时取消所有的订阅
+func (h *watchHub) closeAll() {
+	h.mu.Lock()
+	watchers := make([]*watcher, 0, len(h.watchers))
+	for w := range h.watchers {
+		watchers = append(watchers, w)
+	}
+	h.mu.Unlock()
+	for _, w := range watchers {
+		w.cancel()
+	}
+}
+
+// watcher 一个订阅者
+type watcher struct {
+	db     *DB
+	hub    *watchHub
+	prefix []byte
+	ch     chan Event
+	done   chan struct{}
+	notify chan struct{}
+	once   sync.Once
+
+	// 以下字段由 hub.mu 保护
+	queue   []Event
+	queued  uint64 // 已经加入队列的最后一次写入之后的位置
+	lagging bool   // 是否需要从数据文件中追赶
+
+	sent  uint64 // 已经投递的最后一次写入之后的位置，只在投递协程中访问
+	align bool   // sent 由调用方传入，可能位于事务中间，只在投递协程中访问
+}
+
+// offer 将一次写入中匹配前缀的变更加入队列，需要持有 hub.mu
+func (w *watcher) offer(group *watchGroup) {
+	if w.lagging || group.seq <= w.queued {
+		return
+	}
+	var events []Event
+	for _, event := range group.events {
+		if bytes.HasPrefix(event.Key, w.prefix) {
+			events = append(events, event)
+		}
+	}
+	switch {
+	case group.gap:
+		// 队列之后有没有发布的写入，从数据文件中追赶
+		w.queue = nil
+		w.lagging = true
+	case len(events) == 0:
+		w.queued = group.seq
+		return
+	case len(w.queue)+len(events) > watchMaxPending:
+		// 消费太慢，丢弃队列中的事件，之后从数据文件中追赶
+		w.queue = nil
+		w.lagging = true
+	default:
+		w.queue = append(w.queue, events...)
+		w.queued = group.seq
+	}
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watcher) cancel() {
+	w.once.Do(func() {
+		close(w.done)
+		w.hub.mu.Lock()
+		delete(w.hub.watchers, w)
+		w.hub.numWatcher.Add(-1)
+		w.hub.mu.Unlock()
+	})
+}
+
+// run 投递协程，按顺序将事件发送到 channel 中
+func (w *watcher) run() {
+	defer close(w.ch)
+	for {
+		w.hub.mu.Lock()
+		for len(w.queue) == 0 && !w.lagging {
+			w.hub.mu.Unlock()
+			select {
+			case <-w.notify:
+			case <-w.done:
+				return
+			}
+			w.hub.mu.Lock()
+		}
+		if w.lagging {
+			w.hub.mu.Unlock()
+			if !w.catchUp() {
+				return
+			}
+			continue
+		}
+		events := w.queue
+		w.queue = nil
+		w.hub.mu.Unlock()
+
+		for _, event := range events {
+			if !w.send(event) {
+				return
+			}
+		}
+	}
+}
+
+func (w *watcher) send(event Event) bool {
+	select {
+	case w.ch <- event:
+		w.sent = event.Seq
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// catchUp 从数据文件中读取已经发布的写入，追上最新发布的位置之后恢复从队列中接收
+func (w *watcher) catchUp() bool {
+	for {
+		w.hub.mu.Lock()
+		end := w.hub.lastSeq
+		w.hub.mu.Unlock()
+
+		if end > w.sent {
+			from := w.sent
+			if w.align {
+				// 调用方传入的位置可能位于事务中间，从事务的第一条数据开始读取，事务才能完整投递
+				start, err := w.txnStart(from)
+				if err != nil {
+					return false
+				}
+				from, w.align = start, false
+			}
+			if !w.tail(from, end) {
+				return false
+			}
+			w.sent = end
+		}
+
+		w.hub.mu.Lock()
+		if w.hub.lastSeq == end {
+			w.lagging = false
+			w.queue = nil
+			if end > w.queued {
+				w.queued = end
+			}
+			w.hub.mu.Unlock()
+			return true
+		}
+		w.hub.mu.Unlock()
+	}
+}
+
+// tail 读取数据文件中 (from, end] 范围内的写入并投递，事务只在读到完成标识时投递
+// end 不超过已经发布的位置，读到的写入都已经更新完索引
+func (w *watcher) tail(from, end uint64) bool {
+	db := w.db
+	fromFid, offset := decodeSeq(from)
+	endFid, endOffset := decodeSeq(end)
+
+	db.mu.RLock()
+	var fileIds []uint32
+	for fid := range db.olderFiles {
+		fileIds = append(fileIds, fid)
+	}
+	if db.activeFile != nil {
+		fileIds = append(fileIds, db.activeFile.FileId)
+	}
+	db.mu.RUnlock()
+	sort.Slice(fileIds, func(i, j int) bool { return fileIds[i] < fileIds[j] })
+
+	transactions := make(map[uint64][]Event)
+	for _, fid := range fileIds {
+		if fid < fromFid || fid > endFid {
+			continue
+		}
+		if fid != fromFid {
+			offset = 0
+		}
+		for {
+			var groups []*watchGroup
+			var err error
+			groups, offset, err = w.readGroups(fid, offset, endFid, endOffset, transactions)
+			if err != nil && err != io.EOF {
+				return false
+			}
+			for _, group := range groups {
+				for _, event := range group.events {
+					if !w.send(event) {
+						return false
+					}
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+		}
+	}
+	return true
+}
+
+// readGroups 持有读锁从 offset 开始读取一批数据，返回读到的写入和下一次读取的位置
+// 读到文件末尾或者 end 时返回 io.EOF
+func (w *watcher) readGroups(fid uint32, offset int64, endFid uint32, endOffset int64,
+	transactions map[uint64][]Event) ([]*watchGroup, int64, error) {
+	db := w.db
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dataFile := db.dataFile(fid)
+	if dataFile == nil {
+		return nil, offset, io.EOF
+	}
+
+	var groups []*watchGroup
+	for i := 0; i < watchTailBatch; i++ {
+		if fid == endFid && offset >= endOffset {
+			return groups, offset, io.EOF
+		}
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			return groups, offset, err
+		}
+		offset += size
+		seq := encodeSeq(fid, offset)
+
+		realKey, seqNo := parseLogRecordKey(logRecord.Key)
+		if logRecord.Type == data.LogRecordTxnFinished {
+			if events := transactions[seqNo]; len(events) > 0 {
+				for j := range events {
+					events[j].Seq = seq
+				}
+				groups = append(groups, &watchGroup{seq: seq, events: events})
+			}
+			delete(transactions, seqNo)
+			continue
+		}
+		if !bytes.HasPrefix(realKey, w.prefix) {
+			continue
+		}
+		event := Event{
+			Type:      EventPut,
+			Key:       realKey,
+			Value:     logRecord.Value,
+			Seq:       seq,
+			Timestamp: eventTime(logRecord.Timestamp),
+		}
+		if logRecord.Type == data.LogRecordDeleted {
+			event.Type = EventDelete
+			event.Value = nil
+		}
+		if seqNo == nonTransactionSeqNo {
+			groups = append(groups, &watchGroup{seq: seq, events: []Event{event}})
+		} else {
+			transactions[seqNo] = append(transactions[seqNo], event)
+		}
+	}
+	return groups, offset, nil
+}
+
+// dataFile 根据文件 id 找到数据文件，文件不存在时返回空，在访问此方法前必须持有读锁
+func (db *DB) dataFile(fid uint32) *data.DataFile {
+	if db.activeFile != nil && db.activeFile.FileId == fid {
+		return db.activeFile
+	}
+	return db.olderFiles[fid]
+}
+
+// txnStart 返回 from 所在事务的第一条数据的位置，from 不在事务中间时返回 from
+// 同一个事务的数据是连续写入的，但可能跨越多个数据文件，需要时继续检查之前的文件
+func (w *watcher) txnStart(from uint64) (uint64, error) {
+	fid, end := decodeSeq(from)
+	start := from
+	var txnSeqNo uint64
+	for {
+		seqNo, offset, err := w.openTxn(fid, end)
+		if err != nil {
+			return 0, err
+		}
+		// 之后的文件中的事务没有延续到这个文件的末尾
+		if txnSeqNo != nonTransactionSeqNo && seqNo != txnSeqNo {
+			return start, nil
+		}
+		if seqNo == nonTransactionSeqNo && end > 0 {
+			return start, nil
+		}
+		if seqNo != nonTransactionSeqNo {
+			start, txnSeqNo = encodeSeq(fid, offset), seqNo
+			if offset > 0 {
+				return start, nil
+			}
+		}
+		// 事务从文件开头开始，或者 from 位于文件开头，检查之前的文件的末尾
+		prev, ok := w.prevFile(fid)
+		if !ok {
+			return start, nil
+		}
+		fid, end = prev, math.MaxInt64
+	}
+}
+
+// openTxn 读取数据文件中 end 之前的数据，返回在 end 处还没有完成的事务的序列号和它在这个文件中的第一条数据的位置
+// 没有未完成的事务时序列号为 nonTransactionSeqNo，每次持有读锁读取一批数据
+func (w *watcher) openTxn(fid uint32, end int64) (uint64, int64, error) {
+	db := w.db
+	var seqNo uint64
+	var start, offset int64
+	for {
+		done, err := func() (bool, error) {
+			db.mu.RLock()
+			defer db.mu.RUnlock()
+			dataFile := db.dataFile(fid)
+			if dataFile == nil {
+				return true, nil
+			}
+			for i := 0; i < watchTailBatch; i++ {
+				if offset >= end {
+					return true, nil
+				}
+				logRecord, size, err := dataFile.ReadLogRecord(offset)
+				if err == io.EOF {
+					return true, nil
+				}
+				if err != nil {
+					return true, err
+				}
+				_, recordSeqNo := parseLogRecordKey(logRecord.Key)
+				switch {
+				case logRecord.Type == data.LogRecordTxnFinished:
+					seqNo = nonTransactionSeqNo
+				case recordSeqNo != seqNo:
+					// 非事务的数据会结束之前没有完成标识的事务
+					seqNo, start = recordSeqNo, offset
+				}
+				offset += size
+			}
+			return false, nil
+		}()
+		if done {
+			return seqNo, start, err
+		}
+	}
+}
+
+// prevFile 返回 fid 之前的最后一个数据文件
+func (w *watcher) prevFile(fid uint32) (uint32, bool) {
+	db := w.db
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var prev uint32
+	found := false
+	for id := range db.olderFiles {
+		if id < fid && (!found || id > prev) {
+			prev, found = id, true
+		}
+	}
+	return prev, found
+}
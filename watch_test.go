@@ -0,0 +1,264 @@
+package bitcaskgo
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func receiveEvents(t *testing.T, ch <-chan Event, n int) []Event {
+	var events []Event
+	for i := 0; i < n; i++ {
+		select {
+		case event, ok := <-ch:
+			if !assert.True(t, ok) {
+				return events
+			}
+			events = append(events, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for event %d", i)
+		}
+	}
+	return events
+}
+
+func TestDB_Watch(t *testing.T) {
+	opts := DefaultOptions
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 订阅之前的写入不会收到
+	err = db.Put([]byte("user-0"), []byte("v0"))
+	assert.Nil(t, err)
+
+	ch, cancel := db.Watch([]byte("user-"))
+	err = db.Put([]byte("user-1"), []byte("v1"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("order-1"), []byte("o1"))
+	assert.Nil(t, err)
+	err = db.Delete([]byte("user-0"))
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("user-2"), []byte("v2")))
+	assert.Nil(t, wb.Put([]byte("order-2"), []byte("o2")))
+	assert.Nil(t, wb.Delete([]byte("user-1")))
+	assert.Nil(t, wb.Commit())
+
+	events := receiveEvents(t, ch, 4)
+	assert.Equal(t, EventPut, events[0].Type)
+	assert.Equal(t, []byte("user-1"), events[0].Key)
+	assert.Equal(t, []byte("v1"), events[0].Value)
+	assert.False(t, events[0].Timestamp.IsZero())
+	assert.Equal(t, EventDelete, events[1].Type)
+	assert.Equal(t, []byte("user-0"), events[1].Key)
+	assert.Greater(t, events[1].Seq, events[0].Seq)
+
+	// 同一批次的变更拥有相同的 Seq
+	assert.Equal(t, events[2].Seq, events[3].Seq)
+	assert.Greater(t, events[2].Seq, events[1].Seq)
+	batch := map[string]EventType{string(events[2].Key): events[2].Type, string(events[3].Key): events[3].Type}
+	assert.Equal(t, map[string]EventType{"user-2": EventPut, "user-1": EventDelete}, batch)
+
+	cancel()
+	for range ch {
+	}
+}
+
+func TestDB_WatchFrom(t *testing.T) {
+	opts := DefaultOptions
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 写入多个数据文件
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Delete(utils.GetTestKey(0)))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(1)))
+	assert.Nil(t, wb.Commit())
+
+	// 从头开始回放所有的变更
+	ch, cancel := db.WatchFrom(nil, 0)
+	events := receiveEvents(t, ch, 2002)
+	for i := 0; i < 2000; i++ {
+		assert.Equal(t, EventPut, events[i].Type)
+		assert.Equal(t, utils.GetTestKey(i), events[i].Key)
+	}
+	assert.Equal(t, EventDelete, events[2000].Type)
+	assert.Equal(t, events[2000].Seq, events[2001].Seq)
+
+	// 回放之后继续接收新的变更
+	err = db.Put([]byte("live"), []byte("v"))
+	assert.Nil(t, err)
+	live := receiveEvents(t, ch, 1)
+	assert.Equal(t, []byte("live"), live[0].Key)
+	cancel()
+
+	// 从中间的某个位置继续订阅
+	ch2, cancel2 := db.WatchFrom(nil, events[999].Seq)
+	defer cancel2()
+	resumed := receiveEvents(t, ch2, 1)
+	assert.Equal(t, utils.GetTestKey(1000), resumed[0].Key)
+}
+
+func TestDB_WatchNoWatcher(t *testing.T) {
+	opts := DefaultOptions
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	ch, cancel := db.Watch(nil)
+	assert.Nil(t, db.Put([]byte("key-0"), []byte("v0")))
+	first := receiveEvents(t, ch, 1)
+	cancel()
+	for range ch {
+	}
+
+	// 没有订阅者时写入不分配发布序号
+	ticket := db.watchHub.nextTicket
+	for i := 1; i <= 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Delete(utils.GetTestKey(1)))
+	assert.Nil(t, wb.Commit())
+	assert.Equal(t, ticket, db.watchHub.nextTicket)
+
+	// 之后订阅时从数据文件中读取跳过的写入，再继续接收新的变更
+	ch, cancel = db.WatchFrom(nil, first[0].Seq)
+	defer cancel()
+	assert.Nil(t, db.Put([]byte("live"), []byte("v")))
+	events := receiveEvents(t, ch, 12)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, EventPut, events[i].Type)
+		assert.Equal(t, utils.GetTestKey(i+1), events[i].Key)
+	}
+	assert.Equal(t, EventDelete, events[10].Type)
+	assert.Equal(t, []byte("live"), events[11].Key)
+	assert.Greater(t, events[11].Seq, events[10].Seq)
+}
+
+func TestDB_WatchFromTxnMiddle(t *testing.T) {
+	opts := DefaultOptions
+	opts.DataFileSize = 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put([]byte("before"), []byte("v")))
+	// 事务跨越多个数据文件
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.RandomValue(300)))
+	}
+	assert.Nil(t, wb.Commit())
+
+	positions := make([]*data.LogRecordPos, 0, 10)
+	for i := 0; i < 10; i++ {
+		positions = append(positions, db.index.Get(utils.GetTestKey(i)))
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		return encodeSeq(positions[i].Fid, positions[i].Offset) < encodeSeq(positions[j].Fid, positions[j].Offset)
+	})
+	assert.Less(t, positions[0].Fid, positions[5].Fid)
+
+	// 从事务中间继续订阅时回放完整的事务
+	ch, cancel := db.WatchFrom(nil, endSeq(positions[5]))
+	defer cancel()
+	events := receiveEvents(t, ch, 10)
+	keys := make(map[string]bool)
+	for _, event := range events {
+		assert.Equal(t, events[0].Seq, event.Seq)
+		keys[string(event.Key)] = true
+	}
+	assert.Equal(t, 10, len(keys))
+	assert.False(t, keys["before"])
+}
+
+func TestDB_WatchUnpublished(t *testing.T) {
+	opts := DefaultOptions
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put([]byte("key-0"), []byte("v0")))
+	db.mu.RLock()
+	start := db.currentSeq()
+	db.mu.RUnlock()
+
+	// 没有订阅者时写入数据文件，还没有更新索引
+	key := []byte("slow")
+	pos, ticket, err := db.appendLogRecordWithLock(&data.LogRecord{
+		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value: []byte("v1"),
+		Type:  data.LogRecordNormal,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, noTicket, ticket)
+
+	ch, cancel := db.WatchFrom(nil, start)
+	defer cancel()
+	// 订阅之后的写入排在没有更新完索引的写入之后
+	done := make(chan error)
+	go func() { done <- db.Put([]byte("live"), []byte("v2")) }()
+	assert.Nil(t, <-done)
+	select {
+	case event := <-ch:
+		t.Fatalf("unexpected event %q before the index is updated", event.Key)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	db.index.Put(key, pos)
+	db.publishWrite(ticket, pos, EventPut, key, []byte("v1"))
+	db.endIndexUpdate()
+
+	events := receiveEvents(t, ch, 2)
+	assert.Equal(t, key, events[0].Key)
+	assert.Equal(t, []byte("live"), events[1].Key)
+	assert.Greater(t, events[1].Seq, events[0].Seq)
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+}
+
+func TestDB_WatchSlowConsumer(t *testing.T) {
+	opts := DefaultOptions
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	ch, cancel := db.Watch(nil)
+	defer cancel()
+
+	// 不消费 channel，写入超过队列上限的数据，之后从数据文件中追赶
+	n := watchChanSize + watchMaxPending*2
+	for i := 0; i < n; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+	events := receiveEvents(t, ch, n)
+	for i := 0; i < n; i++ {
+		assert.Equal(t, utils.GetTestKey(i), events[i].Key)
+	}
+}
+
+func TestDB_WatchClose(t *testing.T) {
+	opts := DefaultOptions
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	ch, _ := db.Watch(nil)
+	destroyDB(db)
+	_, ok := <-ch
+	assert.False(t, ok)
+}